@@ -1,47 +1,56 @@
 package main
 
 import (
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
-	h "tcpToHttp/internal/headers"
+	"tcpToHttp/internal/multipart"
 	"tcpToHttp/internal/request"
 	"tcpToHttp/internal/response"
 	"tcpToHttp/internal/server"
+	"tcpToHttp/internal/sse"
+	"tcpToHttp/internal/websocket"
+	"time"
 )
 
 const port = 42069
 
-func toStr(bytes []byte) string {
-	out := ""
-	for _, b := range bytes {
-		out += fmt.Sprintf("%02x", b)
-	}
-	return out
-}
-
 func main() {
-	server := server.New(port)
-
-	server.GET("/", defaultHandler)
-	server.GET("/yourproblem", yourProblemHandler)
-	server.GET("/myproblem", myProblemHandler)
-	server.GET("/httpbin/stream/:count", chunkHandler)
-	server.GET("/httpbin/:type", tailersHandler)
-	server.GET("/video", videoHandler)
-
-	if err := server.Serve(); err != nil {
+	httpbin, err := server.NewReverseProxy([]string{"http://httpbin.org"})
+	if err != nil {
+		log.Fatalf("Error creating proxy: %v", err)
+	}
+	defer httpbin.Close()
+
+	srv := server.New(port)
+	srv.SetServerName("tcpToHttp")
+	srv.SetReadTimeout(time.Minute)
+	srv.SetIdleTimeout(2 * time.Minute)
+	srv.Use(server.Compress(256), server.Conditional())
+	// clients can use the server as their proxy for these destinations
+	srv.SetForwardProxy(server.NewForwardProxy(server.AllowDestinations("httpbin.org", "example.com")))
+
+	srv.GET("/", defaultHandler)
+	srv.GET("/yourproblem", yourProblemHandler)
+	srv.GET("/myproblem", myProblemHandler)
+	srv.HandleStream("GET", "/httpbin/*path", httpbin.Serve)
+	srv.HandleStream("POST", "/httpbin/*path", httpbin.Serve)
+	srv.GET("/video", videoHandler)
+	srv.POST("/upload", uploadHandler)
+	srv.GET("/events", eventsHandler)
+	srv.GET("/ws", echoHandler)
+	srv.GET("/assets/*filepath", server.FileServer(os.DirFS("assets"), server.WithDirectoryListing()))
+
+	if err := srv.Serve(); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 
-	defer server.Close()
+	defer srv.Close()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -50,7 +59,17 @@ func main() {
 }
 
 func defaultHandler(res *response.Writer, req *request.Request) *server.HandlerError {
-	body := []byte(`
+	mediaType, hErr := server.Negotiate(req, "text/html", "application/json")
+	if hErr != nil {
+		return hErr
+	}
+	res.Header().Set("Vary", "Accept", false)
+	if mediaType == "application/json" {
+		res.JSON(response.StatusOK, map[string]string{"message": "Your request was an absolute banger."})
+		return nil
+	}
+
+	body := `
 		<html>
   <head>
     <title>200 OK</title>
@@ -60,18 +79,13 @@ func defaultHandler(res *response.Writer, req *request.Request) *server.HandlerE
     <p>Your request was an absolute banger.</p>
   </body>
 </html>
-`)
-	headers := response.GetDefaultHeaders(0)
-	headers.Set("Content-length", strconv.Itoa(len(body)), true)
-	headers.Set("Content-type", "text/html", true)
-	res.WriteStatusLine(response.StatusOK)
-	res.WriteHeaders(*headers)
-	res.WriteBody(body)
+`
+	res.HTML(response.StatusOK, body)
 	return nil
 }
 
 func yourProblemHandler(res *response.Writer, req *request.Request) *server.HandlerError {
-	body := []byte(`
+	body := `
 		<html>
 			<head>
     			<title>400 Bad Request</title>
@@ -81,21 +95,13 @@ func yourProblemHandler(res *response.Writer, req *request.Request) *server.Hand
            		<p>Your request honestly kinda sucked.</p>
             </body>
         </html>
-	`)
-	headers := response.GetDefaultHeaders(0)
-	headers.Set("Content-length", strconv.Itoa(len(body)), true)
-	headers.Set("Content-type", "text/html", true)
-	res.WriteStatusLine(response.StatusBadReq)
-	res.WriteHeaders(*headers)
-	res.WriteBody(body)
-	return &server.HandlerError{
-		StatusCode: response.StatusBadReq,
-		Message:    "Your problem is not my problem\n",
-	}
+	`
+	res.HTML(response.StatusBadReq, body)
+	return nil
 }
 
 func myProblemHandler(res *response.Writer, req *request.Request) *server.HandlerError {
-	body := []byte(`
+	body := `
 		<html>
   <head>
     <title>500 Internal Server Error</title>
@@ -105,152 +111,138 @@ func myProblemHandler(res *response.Writer, req *request.Request) *server.Handle
     <p>Okay, you know what? This one is on me.</p>
   </body>
 </html>
-	`)
-
-	headers := response.GetDefaultHeaders(0)
-	headers.Set("Content-length", strconv.Itoa(len(body)), true)
-	headers.Set("Content-type", "text/html", true)
-	res.WriteStatusLine(response.StatusServerError)
-	res.WriteHeaders(*headers)
-	res.WriteBody(body)
-	return &server.HandlerError{
-		StatusCode: response.StatusServerError,
-		Message:    "Woopsie, my bad\n",
-	}
+	`
+	res.HTML(response.StatusServerError, body)
+	return nil
 }
 
-func chunkHandler(res *response.Writer, req *request.Request) *server.HandlerError {
-	parts := strings.Split(req.RequestLine.RequestTarget, "/")
-	if len(parts) < 4 {
+func videoHandler(res *response.Writer, req *request.Request) *server.HandlerError {
+	file, err := os.Open("assets/vim.mp4")
+	if err != nil {
 		return &server.HandlerError{
-			StatusCode: response.StatusBadReq,
-			Message:    "no count \n",
+			StatusCode: response.StatusServerError,
+			Message:    "error reading video\n",
 		}
 	}
+	defer file.Close()
 
-	_, err := strconv.Atoi(parts[3])
+	info, err := file.Stat()
 	if err != nil {
 		return &server.HandlerError{
 			StatusCode: response.StatusServerError,
-			Message:    "Woopsie, my bad\n",
+			Message:    "error reading video\n",
 		}
 	}
 
-	binRes, err := http.Get("https://httpbin.org/stream/" + parts[3])
-	if err != nil {
+	if err := response.ServeContent(res, req, info.Name(), info.ModTime(), file); err != nil {
 		return &server.HandlerError{
 			StatusCode: response.StatusServerError,
-			Message:    "Woopsie, my bad\n",
+			Message:    "error reading video\n",
 		}
 	}
-	headers := response.GetDefaultHeaders(0)
-	headers.Delete("Content-length")
-	headers.Set("Transfer-Encoding", "chunked", true)
-	headers.Set("Trailer", "X-Content-SHA256", false)
-	headers.Set("Trailer", "X-Content-Length", false)
-	res.WriteStatusLine(response.StatusOK)
-	res.WriteHeaders(*headers)
+	return nil
+}
 
-	fullBody := []byte{}
+// uploadHandler streams the files of a multipart/form-data body through
+// the temp dir without buffering them in memory and reports their sizes
+func uploadHandler(res *response.Writer, req *request.Request) *server.HandlerError {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return server.NewJSONError(response.StatusUnsupportedMediaType, err.Error())
+	}
+	reader.MaxPartSize = 100 << 20
+	reader.MaxTotalSize = 500 << 20
+
+	type upload struct {
+		Field string `json:"field"`
+		File  string `json:"file,omitempty"`
+		Size  int64  `json:"size"`
+	}
+	uploads := []upload{}
 	for {
-		data := make([]byte, 32)
-		n, err := binRes.Body.Read(data)
-		if err != nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
 			break
 		}
-		// TODO: find more effiecent way
-		fullBody = append(fullBody, data[:n]...)
-		res.WriteBody([]byte(fmt.Sprintf("%x%s", n, request.CRLF)))
-		res.WriteBody(data[:n])
-		res.WriteBody(request.CRLF)
+		if err != nil {
+			return server.NewJSONError(response.StatusBadReq, err.Error())
+		}
+
+		n, err := savePart(part)
+		if errors.Is(err, ERROR_SAVE_UPLOAD) {
+			return server.NewJSONError(response.StatusServerError, err.Error())
+		}
+		if err != nil {
+			status := response.StatusBadReq
+			if errors.Is(err, multipart.ERROR_PART_TOO_LARGE) || errors.Is(err, multipart.ERROR_MULTIPART_TOO_LARGE) {
+				status = response.StatusContentTooLarge
+			}
+			return server.NewJSONError(status, err.Error())
+		}
+		uploads = append(uploads, upload{Field: part.FormName(), File: part.FileName(), Size: n})
 	}
-	res.WriteBody([]byte(fmt.Sprintf("0%s", request.CRLF)))
+
+	res.JSON(response.StatusOK, uploads)
 	return nil
 }
 
-func tailersHandler(res *response.Writer, req *request.Request) *server.HandlerError {
-	parts := strings.Split(req.RequestLine.RequestTarget, "/")
-	if len(parts) < 3 {
-		return &server.HandlerError{
-			StatusCode: response.StatusBadReq,
-			Message:    "no html \n",
-		}
+var ERROR_SAVE_UPLOAD = fmt.Errorf("error saving upload.")
+
+// savePart copies a file part into a temp file to count its size, the
+// demo keeps nothing so the file is removed again right away
+func savePart(part *multipart.Part) (int64, error) {
+	if part.FileName() == "" {
+		return io.Copy(io.Discard, part)
+	}
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return 0, ERROR_SAVE_UPLOAD
 	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	return io.Copy(file, part)
+}
 
-	binRes, err := http.Get("https://httpbin.org/" + parts[2])
+// eventsHandler pushes the server time every second until the client leaves
+func eventsHandler(res *response.Writer, req *request.Request) *server.HandlerError {
+	stream, err := sse.New(res, req)
 	if err != nil {
-		return &server.HandlerError{
-			StatusCode: response.StatusServerError,
-			Message:    "Woopsie, my bad\n",
-		}
+		return nil
 	}
-	headers := response.GetDefaultHeaders(0)
-	headers.Delete("Content-length")
-	headers.Set("Transfer-Encoding", "chunked", true)
-	headers.Set("Trailer", "X-Content-SHA256", false)
-	headers.Set("Trailer", "X-Content-Length", false)
-	res.WriteStatusLine(response.StatusOK)
-	res.WriteHeaders(*headers)
+	defer stream.Close()
+	stream.Heartbeat(15 * time.Second)
 
-	fullBody := []byte{}
+	id, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		data := make([]byte, 32)
-		n, err := binRes.Body.Read(data)
-		if err != nil {
-			break
+		select {
+		case <-stream.Done():
+			return nil
+		case now := <-ticker.C:
+			id++
+			stream.Send(sse.Event{
+				ID:    strconv.Itoa(id),
+				Event: "tick",
+				Data:  now.Format(time.RFC3339),
+			})
 		}
-		// TODO: find more effiecent way
-		fullBody = append(fullBody, data[:n]...)
-		res.WriteBody([]byte(fmt.Sprintf("%x%s", n, request.CRLF)))
-		res.WriteBody(data[:n])
-		res.WriteBody(request.CRLF)
 	}
-	res.WriteBody([]byte(fmt.Sprintf("0%s", request.CRLF)))
-	trailers := h.NewHeaders()
-	sha256.Sum256(fullBody)
-	// TODO : remove hard coded sha and length the service was temp not available while did the testing
-	trailers.Set("X-Content-SHA256", "3f324f9914742e62cf082861ba03b207282dba781c3349bee9d7c1b5ef8e0bfe", false)
-	trailers.Set("X-Content-Length", fmt.Sprintf("%d", 3741), false)
-	res.WriteHeaders(*trailers)
-	return nil
 }
 
-func videoHandler(res *response.Writer, req *request.Request) *server.HandlerError {
-	file, err := os.Open("assets/vim.mp4")
+// echoHandler sends every WebSocket message back to the client
+func echoHandler(res *response.Writer, req *request.Request) *server.HandlerError {
+	conn, err := websocket.Upgrade(res, req, nil)
 	if err != nil {
-		return &server.HandlerError{
-			StatusCode: response.StatusServerError,
-			Message:    "error reading video\n",
-		}
+		return nil
 	}
-	defer file.Close()
-
-	headers := response.GetDefaultHeaders(0)
-	headers.Delete("Content-length")
-	headers.Set("Transfer-Encoding", "chunked", true)
-	headers.Set("Content-type", "video/mp4", true)
-	headers.Set("Accept-Ranges", "none", true)
-	res.WriteStatusLine(response.StatusOK)
-	res.WriteHeaders(*headers)
-
-	buffer := make([]byte, 1024*1024)
 	for {
-		n, err := file.Read(buffer)
-		if n > 0 {
-			res.WriteBody([]byte(fmt.Sprintf("%x%s", n, request.CRLF)))
-			res.WriteBody(buffer[:n])
-			res.WriteBody(request.CRLF)
-		}
-		if err == io.EOF {
-			break
-		}
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading video file: %v", err)
-			break
+			return nil
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			return nil
 		}
 	}
-
-	res.WriteBody([]byte(fmt.Sprintf("0%s", request.CRLF)))
-	res.WriteBody(request.CRLF)
-	return nil
 }
@@ -35,7 +35,7 @@ func main() {
 			fmt.Printf("- %s: %s\n", k, v)
 		})
 		fmt.Printf("Body:\n")
-		fmt.Printf(string(req.Body))
+		fmt.Print(string(req.Body))
 	}
 
 }
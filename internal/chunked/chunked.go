@@ -0,0 +1,145 @@
+package chunked
+
+import (
+	"bytes"
+	"fmt"
+	"tcpToHttp/internal/headers"
+)
+
+var ERROR_MALFORMED_CHUNK = fmt.Errorf("malformed chunked encoding.")
+var ERROR_TRAILERS_TOO_LARGE = fmt.Errorf("trailer section too large.")
+var CRLF = []byte("\r\n")
+
+// maxLineLen caps a chunk-size line with its extensions
+const maxLineLen = 4096
+
+// maxTrailerSize caps the trailer section, like the head of a message
+const maxTrailerSize = 64 << 10
+
+type decoderState int
+
+const (
+	stateSize decoderState = iota
+	stateData
+	stateDataEnd
+	stateTrailers
+	stateDone
+)
+
+// Decoder decodes a chunked body (RFC 9112 §7.1) incrementally. Like the
+// request parser it is handed whatever is buffered and reports how much of
+// it was used, so it never consumes anything past the end of the body
+type Decoder struct {
+	state     decoderState
+	remaining int64
+	// trailerLen counts the bytes of the trailer section decoded so far
+	trailerLen int
+	// Trailers holds the trailer section once the body is done
+	Trailers *headers.Headers
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{Trailers: headers.NewHeaders()}
+}
+
+// Done reports whether the last chunk and the trailer section were decoded
+func (d *Decoder) Done() bool {
+	return d.state == stateDone
+}
+
+// Next decodes data up to the next piece of chunk data, at most max bytes
+// of it. It returns how many bytes of data were consumed and the chunk data
+// within them, a subslice of data. Nothing is consumed when data ends in
+// the middle of the chunk framing, the caller has to read more first
+func (d *Decoder) Next(data []byte, max int) (int, []byte, error) {
+	read := 0
+	for {
+		current := data[read:]
+		switch d.state {
+		case stateSize:
+			idx := bytes.Index(current, CRLF)
+			if idx == -1 {
+				if len(current) > maxLineLen {
+					return 0, nil, ERROR_MALFORMED_CHUNK
+				}
+				return read, nil, nil
+			}
+			size, err := parseSize(current[:idx])
+			if err != nil {
+				return 0, nil, err
+			}
+			read += idx + len(CRLF)
+			if size == 0 {
+				d.state = stateTrailers
+			} else {
+				d.remaining = size
+				d.state = stateData
+			}
+
+		case stateData:
+			n := min(int64(len(current)), d.remaining, int64(max))
+			if n == 0 {
+				return read, nil, nil
+			}
+			d.remaining -= n
+			if d.remaining == 0 {
+				d.state = stateDataEnd
+			}
+			return read + int(n), current[:n], nil
+
+		case stateDataEnd:
+			if len(current) < len(CRLF) {
+				return read, nil, nil
+			}
+			if !bytes.HasPrefix(current, CRLF) {
+				return 0, nil, ERROR_MALFORMED_CHUNK
+			}
+			read += len(CRLF)
+			d.state = stateSize
+
+		case stateTrailers:
+			n, done, err := d.Trailers.Parse(current)
+			if err != nil {
+				return 0, nil, err
+			}
+			read += n
+			d.trailerLen += n
+			if d.trailerLen > maxTrailerSize || !done && d.trailerLen+len(current)-n > maxTrailerSize {
+				return 0, nil, ERROR_TRAILERS_TOO_LARGE
+			}
+			if done {
+				d.state = stateDone
+			}
+			return read, nil, nil
+
+		case stateDone:
+			return read, nil, nil
+		}
+	}
+}
+
+// parseSize parses a chunk-size line, chunk extensions are ignored
+func parseSize(line []byte) (int64, error) {
+	sizeHex, _, _ := bytes.Cut(line, []byte(";"))
+	sizeHex = bytes.TrimRight(sizeHex, " \t")
+	if len(sizeHex) == 0 || len(sizeHex) > 15 {
+		return 0, ERROR_MALFORMED_CHUNK
+	}
+
+	size := int64(0)
+	for _, ch := range sizeHex {
+		var digit byte
+		switch {
+		case ch >= '0' && ch <= '9':
+			digit = ch - '0'
+		case ch >= 'a' && ch <= 'f':
+			digit = ch - 'a' + 10
+		case ch >= 'A' && ch <= 'F':
+			digit = ch - 'A' + 10
+		default:
+			return 0, ERROR_MALFORMED_CHUNK
+		}
+		size = size<<4 | int64(digit)
+	}
+	return size, nil
+}
@@ -0,0 +1,122 @@
+package chunked
+
+import (
+	"io"
+	"strings"
+	"tcpToHttp/internal/testutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decode feeds the pieces of src to a decoder the way a connection
+// delivers them and returns the body and what is left after it
+func decode(d *Decoder, src io.Reader) (string, string, error) {
+	buf := []byte{}
+	body := []byte{}
+	p := make([]byte, 16)
+	for !d.Done() {
+		n, piece, err := d.Next(buf, 4)
+		if err != nil {
+			return string(body), "", err
+		}
+		body = append(body, piece...)
+		buf = buf[n:]
+		if n > 0 {
+			continue
+		}
+		read, err := src.Read(p)
+		if read == 0 && err == io.EOF {
+			return string(body), "", io.ErrUnexpectedEOF
+		}
+		buf = append(buf, p[:read]...)
+	}
+	return string(body), string(buf), nil
+}
+
+func TestDecoderBody(t *testing.T) {
+	// Test: Chunks with extensions and trailers, nothing past the body is used
+	for _, perRead := range []int{1, 3, 1024} {
+		d := NewDecoder()
+		body, rest, err := decode(d, &testutil.ChunkReader{
+			Data:            "5\r\nhello\r\n7;name=value\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\nNEXT",
+			NumBytesPerRead: perRead,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "hello, world", body)
+		checksum, _ := d.Trailers.Get("X-Checksum")
+		assert.Equal(t, "abc", checksum)
+		assert.True(t, strings.HasPrefix("NEXT", rest))
+	}
+
+	// Test: Upper case hex size
+	body, _, err := decode(NewDecoder(), &testutil.ChunkReader{Data: "A\r\n0123456789\r\n0\r\n\r\n", NumBytesPerRead: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", body)
+
+	// Test: Invalid chunk size
+	_, _, err = decode(NewDecoder(), &testutil.ChunkReader{Data: "zz\r\nhello\r\n0\r\n\r\n", NumBytesPerRead: 4})
+	assert.Equal(t, ERROR_MALFORMED_CHUNK, err)
+
+	// Test: Chunk data longer than its size
+	_, _, err = decode(NewDecoder(), &testutil.ChunkReader{Data: "3\r\nhello\r\n0\r\n\r\n", NumBytesPerRead: 4})
+	assert.Equal(t, ERROR_MALFORMED_CHUNK, err)
+
+	// Test: Size overflowing int64
+	_, _, err = decode(NewDecoder(), &testutil.ChunkReader{Data: "ffffffffffffffff\r\n", NumBytesPerRead: 4})
+	assert.Equal(t, ERROR_MALFORMED_CHUNK, err)
+
+	// Test: Body cut off before the last chunk
+	_, _, err = decode(NewDecoder(), &testutil.ChunkReader{Data: "5\r\nhel", NumBytesPerRead: 4})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestDecoder(t *testing.T) {
+	// Test: Chunk data is handed out in pieces of at most max bytes
+	d := NewDecoder()
+	data := []byte("6\r\nabcdef\r\n0\r\n\r\n")
+	n, piece, err := d.Next(data, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, "abcd", string(piece))
+	data = data[n:]
+
+	n, piece, err = d.Next(data, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "ef", string(piece))
+	data = data[n:]
+
+	n, piece, err = d.Next(data, 4)
+	require.NoError(t, err)
+	assert.Empty(t, piece)
+	assert.Equal(t, len(data), n)
+	assert.True(t, d.Done())
+
+	// Test: Nothing is consumed from an incomplete size line
+	d = NewDecoder()
+	n, piece, err = d.Next([]byte("1a"), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, piece)
+}
+
+func TestDecoderTrailerSize(t *testing.T) {
+	// Test: A trailer field that never ends
+	d := NewDecoder()
+	data := []byte("0\r\nX-Big: " + strings.Repeat("a", 70_000))
+	_, _, err := d.Next(data, 10)
+	require.ErrorIs(t, err, ERROR_TRAILERS_TOO_LARGE)
+
+	// Test: Many trailer fields over the limit
+	d = NewDecoder()
+	data = []byte("0\r\n" + strings.Repeat("X-Filler: aaaaaaaaaaaaaaaaaaaa\r\n", 3000) + "\r\n")
+	err = nil
+	for err == nil && !d.Done() {
+		var n int
+		n, _, err = d.Next(data, 10)
+		data = data[n:]
+	}
+	require.ErrorIs(t, err, ERROR_TRAILERS_TOO_LARGE)
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"tcpToHttp/internal/headers"
+	"time"
+)
+
+var ERROR_UNSUPPORTED_SCHEME = fmt.Errorf("unsupported scheme.")
+var ERROR_INVALID_URL = fmt.Errorf("invalid url.")
+
+// Request is a request sent by the Client
+type Request struct {
+	Method string
+	// Addr is the host:port dialed, Target is the request-target sent on
+	// the request line, usually origin-form like "/path?query"
+	Addr    string
+	Target  string
+	Headers *headers.Headers
+	// Body is sent with Content-Length when ContentLength is known (>= 0)
+	// and chunked otherwise, nil means no body
+	Body          io.Reader
+	ContentLength int64
+	// Trailers are sent after a chunked body
+	Trailers *headers.Headers
+}
+
+// NewRequest builds a request for an http:// URL, the Host header is set
+// from it. The ContentLength of bytes, strings and buffer readers is known
+func NewRequest(method, url string, body io.Reader) (*Request, error) {
+	rest, ok := strings.CutPrefix(url, "http://")
+	if !ok {
+		return nil, ERROR_UNSUPPORTED_SCHEME
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+	authority := rest[:end]
+	target, _, _ := strings.Cut(rest[end:], "#")
+	if authority == "" || strings.ContainsAny(authority, "@ \t") {
+		return nil, ERROR_INVALID_URL
+	}
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+
+	addr := authority
+	if _, _, err := net.SplitHostPort(authority); err != nil {
+		addr = net.JoinHostPort(strings.Trim(authority, "[]"), "80")
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Host", authority, true)
+	req := &Request{
+		Method:        method,
+		Addr:          addr,
+		Target:        target,
+		Headers:       h,
+		Body:          body,
+		ContentLength: -1,
+	}
+	switch b := body.(type) {
+	case nil:
+		req.ContentLength = 0
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	}
+	return req, nil
+}
+
+// Client sends requests over plain TCP connections, idle connections are
+// kept per address and reused
+type Client struct {
+	// DialTimeout limits connecting, 0 means no limit
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout limits the wait for the response head once the
+	// request and its body are written, 0 means no limit
+	ResponseHeaderTimeout time.Duration
+	// BodyReadTimeout limits the wait for each read of the response body,
+	// a server that stops sending fails the read. 0 means no limit
+	BodyReadTimeout time.Duration
+	// IdleTimeout is how long an idle connection is kept for reuse
+	IdleTimeout time.Duration
+	// MaxIdlePerHost caps the idle connections kept per address, 0 disables reuse
+	MaxIdlePerHost int
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+func New() *Client {
+	return &Client{
+		DialTimeout:           10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		BodyReadTimeout:       30 * time.Second,
+		IdleTimeout:           90 * time.Second,
+		MaxIdlePerHost:        2,
+		idle:                  make(map[string][]*conn),
+	}
+}
+
+// conn is a connection with the bytes read from it but not used yet
+type conn struct {
+	net.Conn
+	buffered  []byte
+	addr      string
+	idleSince time.Time
+}
+
+func (c *Client) Get(url string) (*Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and reads the response head, the body is streamed and
+// must be closed so the connection can be reused
+func (c *Client) Do(req *Request) (*Response, error) {
+	cn, reused := c.getConn(req.Addr)
+	if cn == nil {
+		var err error
+		cn, err = c.dial(req.Addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := c.roundTrip(cn, req)
+	if err != nil && reused && req.Body == nil && idempotent(req.Method) && retryable(err) {
+		// the server may have closed the idle connection in the meantime,
+		// an idempotent request without body can safely go out again
+		cn, err = c.dial(req.Addr)
+		if err != nil {
+			return nil, err
+		}
+		res, err = c.roundTrip(cn, req)
+	}
+	return res, err
+}
+
+// idempotent reports methods whose repetition has the same effect as a
+// single request (RFC 9110 §9.2.2), a POST may already have been acted on
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func (c *Client) dial(addr string) (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", addr, c.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: netConn, addr: addr}, nil
+}
+
+func (c *Client) roundTrip(cn *conn, req *Request) (*Response, error) {
+	// the body may be streamed from a slow source like a proxied upload,
+	// the wait for the response only starts once it is sent
+	cn.SetDeadline(time.Time{})
+	if err := writeRequest(cn, req); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		cn.SetReadDeadline(time.Now().Add(c.ResponseHeaderTimeout))
+	}
+	res, err := readResponse(cn, req.Method)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	// reading the body sets its own deadline per read
+	cn.SetDeadline(time.Time{})
+
+	reusable := res.parsed.KeepAlive() && (req.Headers == nil || !req.Headers.HasToken("Connection", "close"))
+	res.Body = &body{
+		r:       res.parsed.BodyReader(),
+		conn:    cn,
+		timeout: c.BodyReadTimeout,
+		release: func(complete bool) {
+			if complete && reusable {
+				cn.buffered = res.parsed.Buffered()
+				c.putConn(cn)
+			} else {
+				cn.Close()
+			}
+		},
+	}
+	return res, nil
+}
+
+// getConn returns an idle connection for addr, stale ones are closed
+func (c *Client) getConn(addr string) (*conn, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := c.idle[addr]
+	for len(conns) > 0 {
+		cn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		c.idle[addr] = conns
+		if c.IdleTimeout > 0 && time.Since(cn.idleSince) > c.IdleTimeout {
+			cn.Close()
+			continue
+		}
+		return cn, true
+	}
+	return nil, false
+}
+
+func (c *Client) putConn(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle == nil {
+		c.idle = make(map[string][]*conn)
+	}
+	if len(c.idle[cn.addr]) >= c.MaxIdlePerHost || len(cn.buffered) > 0 {
+		// bytes nobody asked for mean the connection is out of sync
+		cn.Close()
+		return
+	}
+	cn.idleSince = time.Now()
+	c.idle[cn.addr] = append(c.idle[cn.addr], cn)
+}
+
+// CloseIdle closes the connections kept for reuse
+func (c *Client) CloseIdle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conns := range c.idle {
+		for _, cn := range conns {
+			cn.Close()
+		}
+		delete(c.idle, addr)
+	}
+}
+
+func writeRequest(w io.Writer, req *Request) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", req.Method, req.Target)
+
+	h := req.Headers
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	h = h.Clone()
+	if _, ok := h.Get("Host"); !ok {
+		h.Set("Host", req.Addr, true)
+	}
+	h.Delete("Content-Length")
+	h.Delete("Transfer-Encoding")
+	chunked := req.Body != nil && req.ContentLength < 0
+	switch {
+	case chunked:
+		h.Set("Transfer-Encoding", "chunked", true)
+	case req.Body != nil || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH":
+		h.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10), true)
+	}
+	h.ForEach(func(k, v string) {
+		fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+	})
+	bw.WriteString("\r\n")
+
+	// the body goes out as it is read, a slow one like a proxied request
+	// body isn't held back until the buffer fills
+	switch {
+	case chunked:
+		if err := writeChunked(bw, req.Body, req.Trailers); err != nil {
+			return err
+		}
+	case req.Body != nil:
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		n, err := io.CopyN(w, req.Body, req.ContentLength)
+		if err != nil && n < req.ContentLength {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeChunked(w *bufio.Writer, body io.Reader, trailers *headers.Headers) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	w.WriteString("0\r\n")
+	if trailers != nil {
+		trailers.ForEach(func(k, v string) {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		})
+	}
+	_, err := w.WriteString("\r\n")
+	return err
+}
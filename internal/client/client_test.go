@@ -0,0 +1,216 @@
+package client
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/request"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer answers every request with whatever respond writes, the
+// requests are parsed with the server's own parser
+func startServer(t *testing.T, respond func(conn net.Conn, req *request.Request)) (string, *atomic.Int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	accepted := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				var buffered []byte
+				for {
+					req, err := request.HeadFromBuffered(conn, buffered)
+					if err != nil {
+						return
+					}
+					if _, err := req.ReadBody(); err != nil {
+						return
+					}
+					respond(conn, req)
+					buffered = req.Buffered()
+				}
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String(), accepted
+}
+
+func readBody(t *testing.T, res *Response) string {
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	return string(body)
+}
+
+func TestClientResponses(t *testing.T) {
+	url, accepted := startServer(t, func(conn net.Conn, req *request.Request) {
+		switch req.RequestLine.Path {
+		case "/length":
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+		case "/chunked":
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n"+
+				"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: 42\r\n\r\n")
+		case "/continue":
+			io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n")
+		case "/head":
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n")
+		case "/echo":
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: "+strconv.Itoa(len(req.Body))+"\r\n\r\n"+string(req.Body))
+		case "/close":
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\nuntil the end")
+			conn.Close()
+		}
+	})
+	c := New()
+
+	// Test: Content-Length body
+	res, err := c.Get(url + "/length")
+	require.NoError(t, err)
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "OK", res.Reason)
+	assert.Equal(t, int64(5), res.ContentLength)
+	assert.Equal(t, "hello", readBody(t, res))
+
+	// Test: Chunked body with trailers on the same connection
+	res, err = c.Get(url + "/chunked")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.Equal(t, "hello world", readBody(t, res))
+	sum, _ := res.Trailers.Get("X-Sum")
+	assert.Equal(t, "42", sum)
+
+	// Test: Interim responses are skipped, 204 has no body
+	res, err = c.Get(url + "/continue")
+	require.NoError(t, err)
+	assert.Equal(t, 204, int(res.StatusCode))
+	assert.Equal(t, "", readBody(t, res))
+
+	// Test: A response to HEAD has no body whatever Content-Length says
+	req, err := NewRequest("HEAD", url+"/head", nil)
+	require.NoError(t, err)
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), res.ContentLength)
+	assert.Equal(t, "", readBody(t, res))
+
+	// Test: Bodies of known and unknown length are sent
+	res, err = c.Do(must(NewRequest("POST", url+"/echo", strings.NewReader("known"))))
+	require.NoError(t, err)
+	assert.Equal(t, "known", readBody(t, res))
+	req = must(NewRequest("POST", url+"/echo", io.MultiReader(strings.NewReader("un"), strings.NewReader("known"))))
+	req.Trailers = headers.NewHeaders()
+	req.Trailers.Set("X-Sum", "1", true)
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", readBody(t, res))
+	assert.Equal(t, int32(1), accepted.Load())
+
+	// Test: A close-delimited body ends with the connection
+	res, err = c.Get(url + "/close")
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", readBody(t, res))
+	res, err = c.Get(url + "/length")
+	require.NoError(t, err)
+	readBody(t, res)
+	assert.Equal(t, int32(2), accepted.Load())
+}
+
+func TestClientConnections(t *testing.T) {
+	url, accepted := startServer(t, func(conn net.Conn, req *request.Request) {
+		switch req.RequestLine.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+		case "/stall":
+			// the body never arrives in full
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\npart")
+			time.Sleep(200 * time.Millisecond)
+		case "/once":
+			// the server drops the connection after answering
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			conn.Close()
+		default:
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		}
+	})
+	c := New()
+
+	// Test: A pooled connection closed by the server is replaced
+	res, err := c.Get(url + "/once")
+	require.NoError(t, err)
+	readBody(t, res)
+	time.Sleep(20 * time.Millisecond)
+	res, err = c.Get(url + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, res))
+	assert.Equal(t, int32(2), accepted.Load())
+
+	// Test: An abandoned body closes the connection
+	res, err = c.Get(url + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	res, err = c.Get(url + "/")
+	require.NoError(t, err)
+	readBody(t, res)
+	assert.Equal(t, int32(3), accepted.Load())
+
+	// Test: A POST is not retried on a connection the server closed
+	res, err = c.Get(url + "/once")
+	require.NoError(t, err)
+	readBody(t, res)
+	time.Sleep(20 * time.Millisecond)
+	_, err = c.Do(must(NewRequest("POST", url+"/", nil)))
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), accepted.Load())
+
+	// Test: A body that stops arriving fails the read
+	c.BodyReadTimeout = 50 * time.Millisecond
+	res, err = c.Get(url + "/stall")
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// Test: Waiting too long for the response head
+	c.ResponseHeaderTimeout = 50 * time.Millisecond
+	_, err = c.Get(url + "/slow")
+	assert.Error(t, err)
+
+	// Test: The wait for the head starts after a slow body was sent
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("slow"))
+		time.Sleep(100 * time.Millisecond)
+		pw.Write([]byte("body"))
+		pw.Close()
+	}()
+	res, err = c.Do(must(NewRequest("POST", url+"/", pr)))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, res))
+
+	// Test: Only http URLs are supported
+	_, err = c.Get("https://example.com/")
+	assert.Equal(t, ERROR_UNSUPPORTED_SCHEME, err)
+}
+
+func must(req *Request, err error) *Request {
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"io"
+	"net"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/response"
+	"time"
+)
+
+type Response struct {
+	StatusCode response.StatusCode
+	Reason     string
+	Version    string
+	Headers    *headers.Headers
+	// ContentLength is -1 when the length isn't known up front
+	ContentLength int64
+	// Body streams the body, it must be closed
+	Body io.ReadCloser
+	// Trailers holds the trailer section once a chunked Body hit io.EOF
+	Trailers *headers.Headers
+
+	parsed *response.Response
+}
+
+// readResponse reads a response head off cn, the body stays on the
+// connection until it is read through Body
+func readResponse(cn *conn, method string) (*Response, error) {
+	parsed, err := response.HeadFromBuffered(cn.Conn, cn.buffered, method)
+	cn.buffered = nil
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		StatusCode:    parsed.StatusLine.StatusCode,
+		Reason:        parsed.StatusLine.ReasonPhrase,
+		Version:       parsed.StatusLine.HttpVersion,
+		Headers:       parsed.Headers,
+		ContentLength: parsed.ContentLength,
+		Trailers:      parsed.Trailers,
+		parsed:        parsed,
+	}, nil
+}
+
+// body hands the connection back once the body was read to the end or
+// closes it when the body is abandoned
+type body struct {
+	r       io.Reader
+	release func(complete bool)
+	done    bool
+	// timeout limits the wait for each read from conn
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	if b.timeout > 0 {
+		b.conn.SetReadDeadline(time.Now().Add(b.timeout))
+	}
+	n, err := b.r.Read(p)
+	if err != nil {
+		b.finish(err == io.EOF)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.finish(false)
+	return nil
+}
+
+func (b *body) finish(complete bool) {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.release(complete)
+}
@@ -0,0 +1,165 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/headers"
+	"time"
+)
+
+var ERROR_INVALID_COOKIE_NAME = fmt.Errorf("invalid cookie name.")
+var ERROR_INVALID_COOKIE_VALUE = fmt.Errorf("invalid cookie value.")
+var ERROR_INVALID_COOKIE_PATH = fmt.Errorf("invalid cookie path.")
+var ERROR_INVALID_COOKIE_DOMAIN = fmt.Errorf("invalid cookie domain.")
+var ERROR_INSECURE_SAMESITE_NONE = fmt.Errorf("SameSite=None cookie must be Secure.")
+
+// expiresFormat is the sane-cookie-date format from RFC 6265 §4.1.1
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out and lets the browser decide
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time
+	// MaxAge in seconds, 0 leaves the attribute out and a negative value
+	// deletes the cookie right away (Max-Age=0)
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Parse parses the pairs of a Cookie request header (RFC 6265 §4.2.1),
+// malformed pairs are skipped
+func Parse(value string) []*Cookie {
+	cookies := []*Cookie{}
+	for _, pair := range strings.Split(value, ";") {
+		name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || !headers.IsToken([]byte(name)) {
+			continue
+		}
+		if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+			val = val[1 : len(val)-1]
+		}
+		if !validValue(val) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{
+			Name:  name,
+			Value: val,
+		})
+	}
+	return cookies
+}
+
+// validValue checks for cookie-octets, anything but controls, whitespace,
+// DQUOTE, comma, semicolon and backslash
+func validValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if ch <= 0x20 || ch >= 0x7f || ch == '"' || ch == ',' || ch == ';' || ch == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validAttribute(value string) bool {
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		if ch < 0x20 || ch == 0x7f || ch == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			ch := label[i]
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *Cookie) Validate() error {
+	if c.Name == "" || !headers.IsToken([]byte(c.Name)) {
+		return ERROR_INVALID_COOKIE_NAME
+	}
+	if !validValue(c.Value) {
+		return ERROR_INVALID_COOKIE_VALUE
+	}
+	if c.Path != "" && !validAttribute(c.Path) {
+		return ERROR_INVALID_COOKIE_PATH
+	}
+	if c.Domain != "" && !validDomain(c.Domain) {
+		return ERROR_INVALID_COOKIE_DOMAIN
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return ERROR_INSECURE_SAMESITE_NONE
+	}
+	return nil
+}
+
+// String serializes the cookie as a Set-Cookie value
+func (c *Cookie) String() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,65 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Multiple pairs
+	cookies := Parse("session=abc123; theme=dark")
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+
+	// Test: Quoted value and empty value
+	cookies = Parse(`id="42"; empty=`)
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "42", cookies[0].Value)
+	assert.Equal(t, "", cookies[1].Value)
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse("novalue; bad name=1; ok=1; =2; val=a,b")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+}
+
+func TestCookieString(t *testing.T) {
+	// Test: All attributes
+	c := &Cookie{
+		Name:     "session",
+		Value:    "abc123",
+		Domain:   ".example.com",
+		Path:     "/",
+		Expires:  time.Date(2024, time.March, 9, 14, 5, 7, 0, time.FixedZone("CET", 3600)),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteStrict,
+	}
+	s, err := c.String()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc123; Domain=example.com; Path=/; Expires=Sat, 09 Mar 2024 13:05:07 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict", s)
+
+	// Test: Negative MaxAge deletes the cookie
+	s, err = (&Cookie{Name: "session", MaxAge: -1}).String()
+	require.NoError(t, err)
+	assert.Equal(t, "session=; Max-Age=0", s)
+
+	// Test: Invalid cookies
+	_, err = (&Cookie{Name: "bad name", Value: "x"}).String()
+	assert.Equal(t, ERROR_INVALID_COOKIE_NAME, err)
+	_, err = (&Cookie{Name: "a", Value: "x;y"}).String()
+	assert.Equal(t, ERROR_INVALID_COOKIE_VALUE, err)
+	_, err = (&Cookie{Name: "a", Path: "/;x"}).String()
+	assert.Equal(t, ERROR_INVALID_COOKIE_PATH, err)
+	_, err = (&Cookie{Name: "a", Domain: "exa mple.com"}).String()
+	assert.Equal(t, ERROR_INVALID_COOKIE_DOMAIN, err)
+	_, err = (&Cookie{Name: "a", SameSite: SameSiteNone}).String()
+	assert.Equal(t, ERROR_INSECURE_SAMESITE_NONE, err)
+}
@@ -11,7 +11,8 @@ var ERROR_MALFORMED_FIELD_LINE = fmt.Errorf("malformed field line.")
 var ERROR_MALFORMED_FIELD_NAME = fmt.Errorf("malformed field name.")
 var CRLF = []byte("\r\n")
 
-func isToken(str []byte) bool {
+// IsToken reports whether str is a token as defined in RFC 9110 §5.6.2
+func IsToken(str []byte) bool {
 	for _, ch := range str {
 		found := false
 		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' {
@@ -38,34 +39,39 @@ func parseHeader(fieldLine []byte) (string, string, error) {
 
 	key := parts[0]
 	value := bytes.TrimSpace(parts[1])
-	if bytes.HasSuffix(key, []byte(" ")) || !isToken(key) {
+	if bytes.HasSuffix(key, []byte(" ")) || !IsToken(key) {
 		return "", "", ERROR_MALFORMED_FIELD_NAME
 	}
 	return string(key), string(value), nil
 }
 
 type Headers struct {
-	headers map[string]string
+	headers map[string][]string
 }
 
 func NewHeaders() *Headers {
 	return &Headers{
-		headers: map[string]string{},
+		headers: map[string][]string{},
 	}
 }
 
+// Get returns all values of a field combined into one comma separated value
 func (h *Headers) Get(key string) (string, bool) {
-	str, ok := h.headers[strings.ToLower(key)]
-	return str, ok
+	values, ok := h.headers[strings.ToLower(key)]
+	return strings.Join(values, ", "), ok
+}
+
+// Values returns the value of every field line with the given name
+func (h *Headers) Values(key string) []string {
+	return h.headers[strings.ToLower(key)]
 }
 
 func (h *Headers) Set(key, value string, override bool) {
 	lowerKey := strings.ToLower(key)
-	oldValue, ok := h.Get(lowerKey)
-	if !ok || override {
-		h.headers[lowerKey] = value
+	if override {
+		h.headers[lowerKey] = []string{value}
 	} else {
-		h.headers[lowerKey] = strings.Join([]string{oldValue, value}, ", ")
+		h.headers[lowerKey] = append(h.headers[lowerKey], value)
 	}
 }
 
@@ -74,6 +80,19 @@ func (h *Headers) Delete(name string) {
 	delete(h.headers, lowerKey)
 }
 
+// HasToken reports whether a comma separated field like Connection
+// contains the token, tokens are compared case-insensitively
+func (h *Headers) HasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (h *Headers) GetInt(key string, defaultValue int) int {
 	strVal, ok := h.Get(key)
 	if !ok {
@@ -88,9 +107,25 @@ func (h *Headers) GetInt(key string, defaultValue int) int {
 
 }
 
+func (h *Headers) Clone() *Headers {
+	c := NewHeaders()
+	for k, v := range h.headers {
+		c.headers[k] = append([]string{}, v...)
+	}
+	return c
+}
+
+// ForEach calls cb once per field with the values combined, except for
+// Set-Cookie which can't be combined (RFC 9110 §5.3) and gets a call per value
 func (h *Headers) ForEach(cb func(k, v string)) {
 	for k, v := range h.headers {
-		cb(k, v)
+		if k == "set-cookie" {
+			for _, value := range v {
+				cb(k, value)
+			}
+			continue
+		}
+		cb(k, strings.Join(v, ", "))
 	}
 }
 
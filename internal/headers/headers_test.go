@@ -78,3 +78,56 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersSetCookie(t *testing.T) {
+	// Test: Set-Cookie values are never combined
+	headers := NewHeaders()
+	headers.Set("Set-Cookie", "a=1", false)
+	headers.Set("Set-Cookie", "b=2; Expires=Sat, 09 Mar 2024 13:05:07 GMT", false)
+	headers.Set("Vary", "Accept", false)
+	headers.Set("Vary", "Accept-Encoding", false)
+
+	lines := []string{}
+	headers.ForEach(func(k, v string) {
+		lines = append(lines, k+": "+v)
+	})
+	assert.ElementsMatch(t, []string{
+		"set-cookie: a=1",
+		"set-cookie: b=2; Expires=Sat, 09 Mar 2024 13:05:07 GMT",
+		"vary: Accept, Accept-Encoding",
+	}, lines)
+
+	// Test: Clone keeps every value
+	clone := headers.Clone()
+	assert.Equal(t, headers.Values("Set-Cookie"), clone.Values("Set-Cookie"))
+	clone.Delete("Vary")
+	_, ok := headers.Get("Vary")
+	assert.True(t, ok)
+}
+
+func TestParseParams(t *testing.T) {
+	// Test: Media type with parameters
+	value, params, err := ParseParams(`Multipart/Form-Data; Boundary=abc123; charset="utf-8"`)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", value)
+	assert.Equal(t, map[string]string{"boundary": "abc123", "charset": "utf-8"}, params)
+
+	// Test: Quoted values with escapes and semicolons
+	value, params, err = ParseParams(`form-data; name="field"; filename="a \"b\"; c.txt"`)
+	require.NoError(t, err)
+	assert.Equal(t, "form-data", value)
+	assert.Equal(t, `a "b"; c.txt`, params["filename"])
+	assert.Equal(t, "field", params["name"])
+
+	// Test: No parameters
+	value, params, err = ParseParams("text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", value)
+	assert.Empty(t, params)
+
+	// Test: Malformed parameters
+	for _, v := range []string{"", "text/plain; charset", `text/plain; charset="utf-8`, "text/plain; a=b c", "text/plain; =x"} {
+		_, _, err = ParseParams(v)
+		assert.Equal(t, ERROR_MALFORMED_PARAMS, err, v)
+	}
+}
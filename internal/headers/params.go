@@ -0,0 +1,81 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ERROR_MALFORMED_PARAMS = fmt.Errorf("malformed field parameters.")
+
+// ParseParams splits a field value like `text/html; charset="utf-8"` or
+// `form-data; name=file` into the lowercased leading value and its
+// parameters (RFC 9110 §5.6.6), parameter names are lowercased and
+// quoted values are unquoted
+func ParseParams(value string) (string, map[string]string, error) {
+	first, rest, _ := strings.Cut(value, ";")
+	first = strings.ToLower(strings.TrimSpace(first))
+	if first == "" {
+		return "", nil, ERROR_MALFORMED_PARAMS
+	}
+
+	params := map[string]string{}
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		if rest == "" {
+			break
+		}
+
+		name, after, ok := strings.Cut(rest, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || !IsToken([]byte(name)) {
+			return "", nil, ERROR_MALFORMED_PARAMS
+		}
+		after = strings.TrimLeft(after, " \t")
+
+		var val string
+		if strings.HasPrefix(after, `"`) {
+			unquoted, n, ok := unquote(after)
+			if !ok {
+				return "", nil, ERROR_MALFORMED_PARAMS
+			}
+			val = unquoted
+			rest = after[n:]
+		} else {
+			end := strings.IndexByte(after, ';')
+			if end == -1 {
+				end = len(after)
+			}
+			val = strings.TrimSpace(after[:end])
+			if val == "" || !IsToken([]byte(val)) {
+				return "", nil, ERROR_MALFORMED_PARAMS
+			}
+			rest = after[end:]
+		}
+
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && rest[0] != ';' {
+			return "", nil, ERROR_MALFORMED_PARAMS
+		}
+		params[strings.ToLower(name)] = val
+	}
+	return first, params, nil
+}
+
+// unquote reads the quoted-string at the start of s and returns its
+// content and the number of bytes consumed
+func unquote(s string) (string, int, bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, true
+		case '\\':
+			if i+1 == len(s) {
+				return "", 0, false
+			}
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return "", 0, false
+}
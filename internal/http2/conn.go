@@ -0,0 +1,1059 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"time"
+)
+
+var ERROR_BAD_PREFACE = fmt.Errorf("http2: bad connection preface.")
+var ERROR_STREAM_CLOSED = fmt.Errorf("http2: stream closed.")
+
+// connWindowSize is the receive window of the connection, it is larger
+// than the one of a stream so a stream whose body isn't read doesn't
+// stall the others right away
+const connWindowSize = 1 << 20
+
+// Handler serves the request of one stream, the response written to w is
+// sent on the stream once the handler writes it. Handlers of different
+// streams run concurrently
+type Handler func(w *response.Writer, req *request.Request)
+
+type Options struct {
+	// MaxConcurrentStreams limits the streams a client may open at once, a
+	// stream counts until its handler returns even when the client reset
+	// it. A client resetting that many running streams without letting
+	// others finish in between gets GOAWAY. 0 means 100
+	MaxConcurrentStreams uint32
+	// MaxHeaderListSize caps the decoded header fields of a request,
+	// 0 means no limit
+	MaxHeaderListSize uint32
+	// IdleTimeout closes the connection when no stream was open for that
+	// long, 0 means no limit
+	IdleTimeout time.Duration
+	// ReadTimeout limits how long the client may take to send the preface,
+	// a header block or the body of a stream, a stream still receiving its
+	// body after that long is reset. 0 means no limit
+	ReadTimeout time.Duration
+	// WriteTimeout limits how long a stream may take from its headers to
+	// the end of its response and bounds every write to the connection,
+	// a stream not done by then is reset. 0 means no limit
+	WriteTimeout time.Duration
+}
+
+type serverConn struct {
+	conn    net.Conn
+	reader  io.Reader
+	handler Handler
+	opts    Options
+
+	decoder *Decoder
+	encoder Encoder
+	// writeMu keeps the frames of different streams from interleaving
+	writeMu sync.Mutex
+
+	// mu guards the streams and the flow control state, cond is signaled
+	// when a send window grows or a stream goes away
+	mu           sync.Mutex
+	cond         *sync.Cond
+	streams      map[uint32]*stream
+	lastStreamID uint32
+	closed       bool
+	// running counts the handlers that haven't returned, a stream reset
+	// by the client keeps its slot until then. resets counts the streams
+	// the client reset while their handler ran, less the ones it let finish
+	running uint32
+	resets  uint32
+	// peer settings
+	maxFrameSize  uint32
+	initialWindow int32
+	// flow control of the connection
+	sendWindow  int32
+	recvWindow  int32
+	recvUnacked int32
+
+	// a header block continues in CONTINUATION frames until END_HEADERS,
+	// only the read loop touches these
+	headerStream    uint32
+	headerBlock     []byte
+	headerEndStream bool
+
+	handlers sync.WaitGroup
+}
+
+// ServeConn serves an HTTP/2 connection started with prior knowledge,
+// buffered holds bytes already read from conn like the sniffed preface.
+// It returns once the connection is closed and every handler is done
+func ServeConn(conn net.Conn, buffered []byte, handler Handler, opts *Options) error {
+	return newServerConn(conn, buffered, handler, opts).serve(nil)
+}
+
+func newServerConn(conn net.Conn, buffered []byte, handler Handler, opts *Options) *serverConn {
+	sc := &serverConn{
+		conn:          conn,
+		reader:        io.MultiReader(bytes.NewReader(buffered), conn),
+		handler:       handler,
+		decoder:       NewDecoder(defaultHeaderTable),
+		streams:       make(map[uint32]*stream),
+		maxFrameSize:  defaultMaxFrameSize,
+		initialWindow: defaultWindowSize,
+		sendWindow:    defaultWindowSize,
+		recvWindow:    connWindowSize,
+	}
+	if opts != nil {
+		sc.opts = *opts
+	}
+	if sc.opts.MaxConcurrentStreams == 0 {
+		sc.opts.MaxConcurrentStreams = defaultMaxConcurrent
+	}
+	sc.decoder.MaxHeaderListSize = int(sc.opts.MaxHeaderListSize)
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+// serve runs the read loop, upgraded is the request that came in over
+// HTTP/1.1 and gets answered on stream 1
+func (sc *serverConn) serve(upgraded *request.Request) error {
+	settings := []Setting{
+		{SettingMaxConcurrentStreams, sc.opts.MaxConcurrentStreams},
+		{SettingEnablePush, 0},
+	}
+	if sc.opts.MaxHeaderListSize > 0 {
+		settings = append(settings, Setting{SettingMaxHeaderListSize, sc.opts.MaxHeaderListSize})
+	}
+	b := AppendFrame(nil, FrameSettings, 0, 0, appendSettings(nil, settings...))
+	b = AppendFrame(b, FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, connWindowSize-defaultWindowSize))
+	if err := sc.write(b); err != nil {
+		sc.close()
+		return err
+	}
+
+	if upgraded != nil {
+		st := sc.newStream(1)
+		st.remoteClosed = true
+		sc.mu.Lock()
+		sc.streams[1] = st
+		sc.mu.Unlock()
+		sc.lastStreamID = 1
+		sc.startHandler(st, upgraded)
+	}
+
+	if sc.opts.ReadTimeout > 0 {
+		sc.conn.SetReadDeadline(time.Now().Add(sc.opts.ReadTimeout))
+	}
+	err := sc.readPreface()
+	if err == nil {
+		err = sc.readLoop()
+	}
+	var connErr ConnError
+	if errors.As(err, &connErr) {
+		sc.goAway(connErr.Code, connErr.Reason)
+		err = nil
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+		err = nil
+	}
+	sc.close()
+	sc.handlers.Wait()
+	return err
+}
+
+func (sc *serverConn) readPreface() error {
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		return err
+	}
+	if string(preface) != Preface {
+		return ERROR_BAD_PREFACE
+	}
+	return nil
+}
+
+func (sc *serverConn) readLoop() error {
+	first := true
+	for {
+		sc.setReadDeadline()
+		f, err := ReadFrame(sc.reader, defaultMaxFrameSize)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				sc.goAway(ErrCodeNo, "timeout")
+			}
+			return err
+		}
+		// the client preface ends with a SETTINGS frame (RFC 9113 §3.4)
+		if first && f.Type != FrameSettings {
+			return ConnError{ErrCodeProtocol, "expected settings"}
+		}
+		first = false
+
+		err = sc.processFrame(f)
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			sc.resetStream(streamErr.StreamID, streamErr.Code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setReadDeadline limits the wait for the next frame, the rest of a header
+// block has to come within the read timeout and a connection without
+// streams is closed after the idle timeout. Open streams have their own
+// timers, see startTimers
+func (sc *serverConn) setReadDeadline() {
+	sc.mu.Lock()
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+	timeout := time.Duration(0)
+	switch {
+	case sc.headerStream != 0:
+		timeout = sc.opts.ReadTimeout
+	case idle:
+		timeout = sc.opts.IdleTimeout
+	}
+	if timeout > 0 {
+		sc.conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		sc.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if sc.headerStream != 0 && (f.Type != FrameContinuation || f.StreamID != sc.headerStream) {
+		return ConnError{ErrCodeProtocol, "expected continuation"}
+	}
+
+	switch f.Type {
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePing:
+		return sc.processPing(f)
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameContinuation:
+		return sc.processContinuation(f)
+	case FrameData:
+		return sc.processData(f)
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FramePriority:
+		return sc.processPriority(f)
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "goaway on a stream"}
+		}
+		// streams already open are still answered
+		return nil
+	case FramePushPromise:
+		return ConnError{ErrCodeProtocol, "push promise from a client"}
+	}
+	// unknown frame types are ignored (RFC 9113 §4.1)
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "settings on a stream"}
+	}
+	if f.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return ConnError{ErrCodeFrameSize, "settings ack with payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.write(AppendFrame(nil, FrameSettings, FlagAck, 0, nil))
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	sc.mu.Lock()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingMaxFrameSize:
+			sc.maxFrameSize = s.Value
+		case SettingInitialWindowSize:
+			// the change applies to the windows of open streams too (RFC 9113 §6.9.2)
+			delta := int32(s.Value) - sc.initialWindow
+			for _, st := range sc.streams {
+				if int64(st.sendWindow)+int64(delta) > maxWindowSize {
+					sc.mu.Unlock()
+					return ConnError{ErrCodeFlowControl, "window too large"}
+				}
+				st.sendWindow += delta
+			}
+			sc.initialWindow = int32(s.Value)
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	return nil
+}
+
+func (sc *serverConn) processPing(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "ping on a stream"}
+	}
+	if len(f.Payload) != 8 {
+		return ConnError{ErrCodeFrameSize, "bad ping length"}
+	}
+	if f.Has(FlagAck) {
+		return nil
+	}
+	return sc.write(AppendFrame(nil, FramePing, FlagAck, 0, f.Payload))
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "headers on stream 0"}
+	}
+	payload, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.Has(FlagPriority) {
+		if len(payload) < 5 {
+			return ConnError{ErrCodeFrameSize, "short priority"}
+		}
+		if binary.BigEndian.Uint32(payload)&maxWindowSize == f.StreamID {
+			return ConnError{ErrCodeProtocol, "stream depends on itself"}
+		}
+		payload = payload[5:]
+	}
+
+	sc.headerBlock = append([]byte{}, payload...)
+	sc.headerEndStream = f.Has(FlagEndStream)
+	if !f.Has(FlagEndHeaders) {
+		sc.headerStream = f.StreamID
+		return nil
+	}
+	return sc.endHeaders(f.StreamID)
+}
+
+func (sc *serverConn) processContinuation(f *Frame) error {
+	if sc.headerStream == 0 {
+		return ConnError{ErrCodeProtocol, "unexpected continuation"}
+	}
+	sc.headerBlock = append(sc.headerBlock, f.Payload...)
+	if int64(len(sc.headerBlock)) > int64(max(sc.opts.MaxHeaderListSize, 64<<10)) {
+		return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if !f.Has(FlagEndHeaders) {
+		return nil
+	}
+	sc.headerStream = 0
+	return sc.endHeaders(f.StreamID)
+}
+
+// endHeaders handles a complete header block, it opens a stream or holds
+// the trailers of an open one
+func (sc *serverConn) endHeaders(id uint32) error {
+	// the block is decoded even for a refused stream to keep the dynamic
+	// table in sync with the client
+	fields, err := sc.decoder.Decode(sc.headerBlock)
+	sc.headerBlock = nil
+	if errors.Is(err, ERROR_HEADER_LIST_TOO_LARGE) {
+		return StreamError{id, ErrCodeRefusedStream, err.Error()}
+	}
+	if err != nil {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+	if st != nil {
+		return sc.processTrailers(st, fields)
+	}
+	if id%2 == 0 {
+		return ConnError{ErrCodeProtocol, "even stream id"}
+	}
+	if id <= sc.lastStreamID {
+		return StreamError{id, ErrCodeStreamClosed, "headers on a closed stream"}
+	}
+	sc.lastStreamID = id
+
+	// handlers of reset streams still count, otherwise a client sending
+	// HEADERS and RST_STREAM in a loop starts any number of them
+	sc.mu.Lock()
+	refused := sc.running >= sc.opts.MaxConcurrentStreams
+	sc.mu.Unlock()
+	if refused {
+		return StreamError{id, ErrCodeRefusedStream, "too many streams"}
+	}
+
+	st = sc.newStream(id)
+	var body io.Reader
+	if sc.headerEndStream {
+		st.remoteClosed = true
+	} else {
+		body = st.body
+	}
+	req, err := newRequest(fields, body)
+	if err != nil {
+		return StreamError{id, ErrCodeProtocol, err.Error()}
+	}
+	if length, ok := req.Headers.Get("Content-Length"); ok {
+		st.contentLength, err = strconv.ParseInt(length, 10, 64)
+		if err != nil || st.contentLength < 0 {
+			return StreamError{id, ErrCodeProtocol, "invalid content-length"}
+		}
+		if sc.headerEndStream && st.contentLength != 0 {
+			return StreamError{id, ErrCodeProtocol, "content-length mismatch"}
+		}
+	}
+
+	sc.mu.Lock()
+	sc.streams[id] = st
+	sc.mu.Unlock()
+	sc.startHandler(st, req)
+	return nil
+}
+
+func (sc *serverConn) processTrailers(st *stream, fields []HeaderField) error {
+	if st.isRemoteClosed() {
+		return StreamError{st.id, ErrCodeStreamClosed, "headers after end of stream"}
+	}
+	if !sc.headerEndStream {
+		return StreamError{st.id, ErrCodeProtocol, "trailers without end of stream"}
+	}
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return StreamError{st.id, ErrCodeProtocol, "pseudo-header in trailers"}
+		}
+	}
+	// trailers aren't exposed to handlers, the stream just ends
+	return sc.endStream(st)
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "data on stream 0"}
+	}
+	size := int32(len(f.Payload))
+	sc.mu.Lock()
+	if size > sc.recvWindow {
+		sc.mu.Unlock()
+		return ConnError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	sc.recvWindow -= size
+	st := sc.streams[f.StreamID]
+	sc.mu.Unlock()
+
+	if st == nil || st.isRemoteClosed() {
+		// the data still counts against the connection window
+		sc.returnWindow(nil, size)
+		if f.StreamID > sc.lastStreamID {
+			return ConnError{ErrCodeProtocol, "data on an idle stream"}
+		}
+		return StreamError{f.StreamID, ErrCodeStreamClosed, "data on a closed stream"}
+	}
+
+	sc.mu.Lock()
+	if size > st.recvWindow {
+		sc.mu.Unlock()
+		sc.returnWindow(nil, size)
+		return StreamError{st.id, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	st.recvWindow -= size
+	sc.mu.Unlock()
+
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	// padding is flow controlled but never read by the handler
+	if padding := size - int32(len(data)); padding > 0 {
+		sc.returnWindow(st, padding)
+	}
+
+	st.received += int64(len(data))
+	if st.contentLength >= 0 && st.received > st.contentLength {
+		return StreamError{st.id, ErrCodeProtocol, "content-length mismatch"}
+	}
+	st.body.write(data)
+	if f.Has(FlagEndStream) {
+		return sc.endStream(st)
+	}
+	return nil
+}
+
+// endStream handles the END_STREAM flag of a client, the body is complete
+func (sc *serverConn) endStream(st *stream) error {
+	if st.contentLength >= 0 && st.received != st.contentLength {
+		return StreamError{st.id, ErrCodeProtocol, "content-length mismatch"}
+	}
+	sc.mu.Lock()
+	st.remoteClosed = true
+	if st.readTimer != nil {
+		st.readTimer.Stop()
+	}
+	sc.mu.Unlock()
+	st.body.closeWithError(io.EOF)
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "bad window update length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.Payload) & maxWindowSize)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if f.StreamID == 0 {
+		if increment == 0 {
+			return ConnError{ErrCodeProtocol, "zero window increment"}
+		}
+		if int64(sc.sendWindow)+increment > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "window too large"}
+		}
+		sc.sendWindow += int32(increment)
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "window update on an idle stream"}
+	}
+	st := sc.streams[f.StreamID]
+	if st == nil {
+		// the stream may have just been closed on our side
+		return nil
+	}
+	if increment == 0 {
+		return StreamError{st.id, ErrCodeProtocol, "zero window increment"}
+	}
+	if int64(st.sendWindow)+increment > maxWindowSize {
+		return StreamError{st.id, ErrCodeFlowControl, "window too large"}
+	}
+	st.sendWindow += int32(increment)
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "rst_stream on stream 0"}
+	}
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "bad rst_stream length"}
+	}
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "rst_stream on an idle stream"}
+	}
+	sc.mu.Lock()
+	st := sc.streams[f.StreamID]
+	if st != nil {
+		sc.resets++
+	}
+	tooMany := sc.resets >= sc.opts.MaxConcurrentStreams
+	sc.mu.Unlock()
+	if st != nil {
+		sc.closeStream(st)
+	}
+	// a client that keeps resetting the streams it opens only makes work
+	if tooMany {
+		return ConnError{ErrCodeEnhanceYourCalm, "too many resets"}
+	}
+	return nil
+}
+
+func (sc *serverConn) processPriority(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "priority on stream 0"}
+	}
+	if len(f.Payload) != 5 {
+		return StreamError{f.StreamID, ErrCodeFrameSize, "bad priority length"}
+	}
+	if binary.BigEndian.Uint32(f.Payload)&maxWindowSize == f.StreamID {
+		return StreamError{f.StreamID, ErrCodeProtocol, "stream depends on itself"}
+	}
+	// priorities are only a hint (RFC 9113 §5.3), streams are served as
+	// their handlers write
+	return nil
+}
+
+func (sc *serverConn) newStream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := &stream{
+		id:            id,
+		sc:            sc,
+		sendWindow:    sc.initialWindow,
+		recvWindow:    defaultWindowSize,
+		contentLength: -1,
+	}
+	st.body = newPipe(func(n int) { sc.returnWindow(st, int32(n)) })
+	return st
+}
+
+func (sc *serverConn) startHandler(st *stream, req *request.Request) {
+	sc.startTimers(st)
+	sc.mu.Lock()
+	sc.running++
+	sc.mu.Unlock()
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer func() {
+			sc.mu.Lock()
+			sc.running--
+			sc.mu.Unlock()
+		}()
+		w := response.NewTransportWriter(st)
+		w.SetHeadRequest(req.RequestLine.Method == "HEAD")
+		sc.handler(w, req)
+		st.finish(w.KeepAlive())
+	}()
+}
+
+// startTimers arms the read and write timeouts of a stream, a client that
+// stalls sending the body or reading the response would otherwise keep
+// the handler blocked in a read or waiting for window forever
+func (sc *serverConn) startTimers(st *stream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.opts.ReadTimeout > 0 && !st.remoteClosed {
+		st.readTimer = time.AfterFunc(sc.opts.ReadTimeout, func() { sc.expire(st, true) })
+	}
+	if sc.opts.WriteTimeout > 0 {
+		st.writeTimer = time.AfterFunc(sc.opts.WriteTimeout, func() { sc.expire(st, false) })
+	}
+}
+
+// expire resets a stream that ran past a timeout, the read timeout only
+// applies while the body is still coming
+func (sc *serverConn) expire(st *stream, read bool) {
+	sc.mu.Lock()
+	open := sc.streams[st.id] == st && !(read && st.remoteClosed)
+	sc.mu.Unlock()
+	if open {
+		sc.resetStream(st.id, ErrCodeCancel)
+	}
+}
+
+// returnWindow hands flow control credit back to the client once the data
+// was read, updates are batched until half a window is consumed
+func (sc *serverConn) returnWindow(st *stream, n int32) {
+	sc.mu.Lock()
+	b := []byte{}
+	sc.recvUnacked += n
+	if sc.recvUnacked >= defaultWindowSize/2 {
+		b = AppendFrame(b, FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, uint32(sc.recvUnacked)))
+		sc.recvWindow += sc.recvUnacked
+		sc.recvUnacked = 0
+	}
+	// a closed stream only needs the connection window back
+	if st != nil && !st.remoteClosed && !st.reset {
+		st.recvUnacked += n
+		if st.recvUnacked >= defaultWindowSize/2 {
+			b = AppendFrame(b, FrameWindowUpdate, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(st.recvUnacked)))
+			st.recvWindow += st.recvUnacked
+			st.recvUnacked = 0
+		}
+	}
+	sc.mu.Unlock()
+	if len(b) > 0 {
+		sc.write(b)
+	}
+}
+
+// resetStream sends RST_STREAM and stops the handler of the stream
+func (sc *serverConn) resetStream(id uint32, code ErrCode) {
+	sc.write(AppendFrame(nil, FrameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code))))
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+	if st != nil {
+		sc.closeStream(st)
+	}
+}
+
+// closeStream forgets the stream, its handler sees ERROR_STREAM_CLOSED on
+// further reads and writes
+func (sc *serverConn) closeStream(st *stream) {
+	sc.mu.Lock()
+	st.reset = true
+	st.stopTimers()
+	delete(sc.streams, st.id)
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.discardBody(st)
+}
+
+// discardBody drops the unread body of a closed stream and returns its
+// share of the connection window
+func (sc *serverConn) discardBody(st *stream) {
+	if n := st.body.closeWithError(ERROR_STREAM_CLOSED); n > 0 {
+		sc.returnWindow(nil, int32(n))
+	}
+}
+
+func (sc *serverConn) goAway(code ErrCode, reason string) {
+	payload := binary.BigEndian.AppendUint32(nil, sc.lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	sc.write(AppendFrame(nil, FrameGoAway, 0, 0, payload))
+}
+
+// close closes the connection and stops every stream still open
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	streams := sc.streams
+	sc.streams = make(map[uint32]*stream)
+	for _, st := range streams {
+		st.reset = true
+		st.stopTimers()
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	sc.conn.Close()
+	for _, st := range streams {
+		st.body.closeWithError(ERROR_STREAM_CLOSED)
+	}
+}
+
+func (sc *serverConn) write(b []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	if sc.opts.WriteTimeout > 0 {
+		sc.conn.SetWriteDeadline(time.Now().Add(sc.opts.WriteTimeout))
+	}
+	_, err := sc.conn.Write(b)
+	if err != nil {
+		// a frame may have been cut short, nothing more can be sent
+		sc.conn.Close()
+	}
+	return err
+}
+
+// writeHeaders sends a header block split into HEADERS and CONTINUATION
+// frames, the frames of a block can't be interleaved with any other
+func (sc *serverConn) writeHeaders(id uint32, fields []HeaderField, endStream bool) error {
+	block := sc.encoder.Encode(nil, fields)
+	sc.mu.Lock()
+	maxSize := int(sc.maxFrameSize)
+	sc.mu.Unlock()
+
+	flags := uint8(0)
+	if endStream {
+		flags |= FlagEndStream
+	}
+	b := []byte{}
+	frameType := FrameHeaders
+	for {
+		chunk := block[:min(len(block), maxSize)]
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+		b = AppendFrame(b, frameType, flags, id, chunk)
+		if len(block) == 0 {
+			break
+		}
+		frameType = FrameContinuation
+		flags = 0
+	}
+	return sc.write(b)
+}
+
+// stream is a request and its response, it is the response.Transport the
+// handler writes to
+type stream struct {
+	id   uint32
+	sc   *serverConn
+	body *pipe
+
+	// guarded by sc.mu
+	sendWindow  int32
+	recvWindow  int32
+	recvUnacked int32
+	reset       bool
+	readTimer   *time.Timer
+	writeTimer  *time.Timer
+
+	// remoteClosed is set once the client ended the stream, guarded by sc.mu
+	remoteClosed bool
+
+	// only touched by the read loop
+	received      int64
+	contentLength int64
+
+	// only touched by the handler
+	headersSent bool
+	endSent     bool
+}
+
+func (st *stream) isRemoteClosed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.remoteClosed
+}
+
+// stopTimers is called with sc.mu held once the stream is done
+func (st *stream) stopTimers() {
+	if st.readTimer != nil {
+		st.readTimer.Stop()
+	}
+	if st.writeTimer != nil {
+		st.writeTimer.Stop()
+	}
+}
+
+func (st *stream) closed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.reset || st.sc.closed
+}
+
+func (st *stream) WriteHead(statusCode response.StatusCode, h *headers.Headers) error {
+	if st.closed() {
+		return ERROR_STREAM_CLOSED
+	}
+	fields := []HeaderField{{":status", strconv.Itoa(int(statusCode))}}
+	fields = appendFields(fields, h)
+	if !statusCode.IsInformational() {
+		st.headersSent = true
+	}
+	return st.sc.writeHeaders(st.id, fields, false)
+}
+
+func (st *stream) WriteData(p []byte) (int, error) {
+	sc := st.sc
+	written := 0
+	for written < len(p) {
+		sc.mu.Lock()
+		for !st.reset && !sc.closed && (sc.sendWindow <= 0 || st.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if st.reset || sc.closed {
+			sc.mu.Unlock()
+			return written, ERROR_STREAM_CLOSED
+		}
+		n := min(len(p)-written, int(sc.sendWindow), int(st.sendWindow), int(sc.maxFrameSize))
+		sc.sendWindow -= int32(n)
+		st.sendWindow -= int32(n)
+		sc.mu.Unlock()
+
+		if err := sc.write(AppendFrame(nil, FrameData, 0, st.id, p[written:written+n])); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (st *stream) WriteTrailers(h *headers.Headers) error {
+	fields := appendFields(nil, h)
+	if len(fields) == 0 {
+		// the stream is ended by finish
+		return nil
+	}
+	if st.closed() {
+		return ERROR_STREAM_CLOSED
+	}
+	st.endSent = true
+	return st.sc.writeHeaders(st.id, fields, true)
+}
+
+// finish ends the stream once the handler returned, ok is false when the
+// response couldn't be completed and the stream is reset instead
+func (st *stream) finish(ok bool) {
+	sc := st.sc
+	if st.closed() {
+		return
+	}
+	switch {
+	case !ok || !st.headersSent:
+		sc.resetStream(st.id, ErrCodeInternal)
+		return
+	case !st.endSent:
+		sc.write(AppendFrame(nil, FrameData, FlagEndStream, st.id, nil))
+	}
+
+	sc.mu.Lock()
+	st.stopTimers()
+	delete(sc.streams, st.id)
+	if sc.resets > 0 {
+		sc.resets--
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	// a body the handler didn't wait for isn't needed anymore (RFC 9113 §8.1)
+	if !st.isRemoteClosed() {
+		sc.write(AppendFrame(nil, FrameRSTStream, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeNo))))
+	}
+	sc.discardBody(st)
+}
+
+// appendFields converts header fields to lowercase HPACK fields, connection
+// specific ones are dropped (RFC 9113 §8.2.2)
+func appendFields(fields []HeaderField, h *headers.Headers) []HeaderField {
+	if h == nil {
+		return fields
+	}
+	h.ForEach(func(k, v string) {
+		if connectionSpecific(k) {
+			return
+		}
+		fields = append(fields, HeaderField{strings.ToLower(k), v})
+	})
+	return fields
+}
+
+func connectionSpecific(name string) bool {
+	switch strings.ToLower(name) {
+	case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+		return true
+	}
+	return false
+}
+
+// newRequest builds the request of a stream from its header fields,
+// malformed requests (RFC 9113 §8.1.1) are rejected
+func newRequest(fields []HeaderField, body io.Reader) (*request.Request, error) {
+	pseudo := map[string]string{}
+	h := headers.NewHeaders()
+	cookies := []string{}
+	regular := false
+	for _, f := range fields {
+		if strings.ToLower(f.Name) != f.Name {
+			return nil, fmt.Errorf("uppercase field name %q", f.Name)
+		}
+		if name, ok := strings.CutPrefix(f.Name, ":"); ok {
+			if regular {
+				return nil, fmt.Errorf("pseudo-header %q after regular fields", f.Name)
+			}
+			switch name {
+			case "method", "scheme", "path", "authority":
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %q", f.Name)
+			}
+			if _, ok := pseudo[name]; ok {
+				return nil, fmt.Errorf("duplicate pseudo-header %q", f.Name)
+			}
+			pseudo[name] = f.Value
+			continue
+		}
+
+		regular = true
+		if !headers.IsToken([]byte(f.Name)) || f.Name == "" {
+			return nil, fmt.Errorf("invalid field name %q", f.Name)
+		}
+		if connectionSpecific(f.Name) || (f.Name == "te" && f.Value != "trailers") {
+			return nil, fmt.Errorf("connection-specific field %q", f.Name)
+		}
+		if f.Name == "cookie" {
+			// cookie crumbs are joined back into one field (RFC 9113 §8.2.3)
+			cookies = append(cookies, f.Value)
+			continue
+		}
+		h.Set(f.Name, f.Value, false)
+	}
+	if len(cookies) > 0 {
+		h.Set("Cookie", strings.Join(cookies, "; "), true)
+	}
+
+	method := pseudo["method"]
+	authority, hasAuthority := pseudo["authority"]
+	target := pseudo["path"]
+	if method == "CONNECT" {
+		_, hasScheme := pseudo["scheme"]
+		_, hasPath := pseudo["path"]
+		if !hasAuthority || hasScheme || hasPath {
+			return nil, fmt.Errorf("malformed CONNECT request")
+		}
+		target = authority
+	} else if method == "" || pseudo["scheme"] == "" || target == "" {
+		return nil, fmt.Errorf("missing pseudo-header")
+	}
+
+	// :authority stands in for Host (RFC 9113 §8.3.1)
+	if _, ok := h.Get("Host"); !ok && hasAuthority {
+		h.Set("Host", authority, true)
+	}
+	return request.NewRequest(method, target, "2.0", h, body)
+}
+
+// pipe buffers the DATA of a stream until the handler reads it, the
+// client can't send more than the stream window so it stays bounded
+type pipe struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	err    error
+	onRead func(n int)
+}
+
+func newPipe(onRead func(n int)) *pipe {
+	p := &pipe{onRead: onRead}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if p.buf.Len() == 0 {
+		err := p.err
+		p.mu.Unlock()
+		return 0, err
+	}
+	n, _ := p.buf.Read(b)
+	p.mu.Unlock()
+	p.onRead(n)
+	return n, nil
+}
+
+func (p *pipe) write(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	p.buf.Write(b)
+	p.cond.Broadcast()
+}
+
+// closeWithError ends the body, io.EOF lets the handler read what is
+// buffered first. Any other error drops it and returns how much was dropped
+func (p *pipe) closeWithError(err error) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil || p.err == io.EOF {
+		p.err = err
+	}
+	p.cond.Broadcast()
+	if err == io.EOF {
+		return 0
+	}
+	// nobody reads what is left
+	n := p.buf.Len()
+	p.buf.Reset()
+	return n
+}
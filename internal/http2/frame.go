@@ -0,0 +1,197 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type FrameType uint8
+
+// frame types from RFC 9113 §6
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+const (
+	FlagEndStream  = 0x1
+	FlagAck        = 0x1
+	FlagEndHeaders = 0x4
+	FlagPadded     = 0x8
+	FlagPriority   = 0x20
+)
+
+type ErrCode uint32
+
+// error codes from RFC 9113 §7
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+type SettingID uint16
+
+// settings from RFC 9113 §6.5.2
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+const (
+	frameHeaderLen       = 9
+	defaultMaxFrameSize  = 16384
+	maxFrameSizeLimit    = 1<<24 - 1
+	defaultWindowSize    = 65535
+	maxWindowSize        = 1<<31 - 1
+	defaultHeaderTable   = 4096
+	defaultMaxConcurrent = 100
+)
+
+// Preface is the client connection preface of RFC 9113 §3.4
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// ConnError is a connection error, the connection is closed with GOAWAY
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+// StreamError only resets the stream with RST_STREAM
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f *Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// ReadFrame reads a frame, payloads larger than maxSize are a FRAME_SIZE_ERROR
+func ReadFrame(r io.Reader, maxSize uint32) (*Frame, error) {
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	if length > maxSize {
+		return nil, ConnError{ErrCodeFrameSize, "frame too large"}
+	}
+	f := &Frame{
+		Type:     FrameType(head[3]),
+		Flags:    head[4],
+		StreamID: binary.BigEndian.Uint32(head[5:]) & maxWindowSize,
+		Payload:  make([]byte, length),
+	}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func AppendFrame(b []byte, t FrameType, flags uint8, streamID uint32, payload []byte) []byte {
+	length := len(payload)
+	b = append(b, byte(length>>16), byte(length>>8), byte(length), byte(t), flags)
+	b = binary.BigEndian.AppendUint32(b, streamID&maxWindowSize)
+	return append(b, payload...)
+}
+
+// unpad strips the padding of a DATA or HEADERS frame (RFC 9113 §6.1)
+func unpad(f *Frame) ([]byte, error) {
+	payload := f.Payload
+	if !f.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, ConnError{ErrCodeProtocol, "missing pad length"}
+	}
+	padLen := int(payload[0])
+	payload = payload[1:]
+	if padLen > len(payload) {
+		return nil, ConnError{ErrCodeProtocol, "padding longer than payload"}
+	}
+	return payload[:len(payload)-padLen], nil
+}
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "bad settings length"}
+	}
+	settings := []Setting{}
+	for i := 0; i < len(payload); i += 6 {
+		s := Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		}
+		switch s.ID {
+		case SettingEnablePush:
+			if s.Value > 1 {
+				return nil, ConnError{ErrCodeProtocol, "invalid enable push"}
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return nil, ConnError{ErrCodeFlowControl, "initial window too large"}
+			}
+		case SettingMaxFrameSize:
+			if s.Value < defaultMaxFrameSize || s.Value > maxFrameSizeLimit {
+				return nil, ConnError{ErrCodeProtocol, "invalid max frame size"}
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func appendSettings(b []byte, settings ...Setting) []byte {
+	for _, s := range settings {
+		b = binary.BigEndian.AppendUint16(b, uint16(s.ID))
+		b = binary.BigEndian.AppendUint32(b, s.Value)
+	}
+	return b
+}
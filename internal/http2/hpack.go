@@ -0,0 +1,350 @@
+package http2
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ERROR_HPACK_DECODE = fmt.Errorf("hpack: malformed header block.")
+var ERROR_HPACK_INDEX = fmt.Errorf("hpack: invalid table index.")
+var ERROR_HPACK_HUFFMAN = fmt.Errorf("hpack: invalid huffman string.")
+var ERROR_HPACK_TABLE_SIZE = fmt.Errorf("hpack: table size update over the limit.")
+var ERROR_HEADER_LIST_TOO_LARGE = fmt.Errorf("hpack: header list too large.")
+
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// size is the table size of an entry from RFC 7541 §4.1
+func (f HeaderField) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// staticTable is RFC 7541 Appendix A, index 1 is staticTable[0]
+var staticTable = []HeaderField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// dynamicTable is the FIFO of RFC 7541 §2.3.2, the newest entry is last
+type dynamicTable struct {
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(size int) {
+	t.maxSize = size
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	n := 0
+	for t.size > t.maxSize && n < len(t.entries) {
+		t.size -= t.entries[n].size()
+		n++
+	}
+	t.entries = t.entries[n:]
+}
+
+// Decoder decodes header blocks, it keeps the dynamic table between them
+// so every block of a connection must go through the same Decoder
+type Decoder struct {
+	table dynamicTable
+	// maxTableSize is the limit we advertised with SETTINGS_HEADER_TABLE_SIZE
+	maxTableSize int
+	// MaxHeaderListSize caps the decoded fields, 0 means no limit
+	MaxHeaderListSize int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+func (d *Decoder) field(index int) (HeaderField, error) {
+	switch {
+	case index == 0:
+		return HeaderField{}, ERROR_HPACK_INDEX
+	case index <= len(staticTable):
+		return staticTable[index-1], nil
+	}
+	index -= len(staticTable) + 1
+	if index >= len(d.table.entries) {
+		return HeaderField{}, ERROR_HPACK_INDEX
+	}
+	return d.table.entries[len(d.table.entries)-1-index], nil
+}
+
+// Decode decodes a complete header block (RFC 7541 §6)
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	fields := []HeaderField{}
+	listSize := 0
+	for len(block) > 0 {
+		b := block[0]
+		var f HeaderField
+		var err error
+		switch {
+		case b&0x80 != 0:
+			// indexed header field
+			var index uint64
+			index, block, err = readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err = d.field(int(index))
+			if err != nil {
+				return nil, err
+			}
+
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			f, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+
+		case b&0xe0 == 0x20:
+			// dynamic table size update, only allowed before any field
+			if len(fields) > 0 {
+				return nil, ERROR_HPACK_DECODE
+			}
+			var size uint64
+			size, block, err = readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, ERROR_HPACK_TABLE_SIZE
+			}
+			d.table.setMaxSize(int(size))
+			continue
+
+		default:
+			// literal without indexing or never indexed
+			f, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		listSize += f.size()
+		if d.MaxHeaderListSize > 0 && listSize > d.MaxHeaderListSize {
+			return nil, ERROR_HEADER_LIST_TOO_LARGE
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (d *Decoder) readLiteral(block []byte, prefix uint8) (HeaderField, []byte, error) {
+	index, block, err := readInt(block, prefix)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var f HeaderField
+	if index == 0 {
+		f.Name, block, err = readString(block)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	} else {
+		named, err := d.field(int(index))
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		f.Name = named.Name
+	}
+	f.Value, block, err = readString(block)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	return f, block, nil
+}
+
+// readInt decodes an integer with an N-bit prefix (RFC 7541 §5.1)
+func readInt(block []byte, prefix uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, ERROR_HPACK_DECODE
+	}
+	max := uint64(1)<<prefix - 1
+	value := uint64(block[0]) & max
+	block = block[1:]
+	if value < max {
+		return value, block, nil
+	}
+
+	shift := uint(0)
+	for len(block) > 0 {
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+		shift += 7
+		// anything this large is an attack rather than a header
+		if shift > 28 {
+			return 0, nil, ERROR_HPACK_DECODE
+		}
+	}
+	return 0, nil, ERROR_HPACK_DECODE
+}
+
+func appendInt(b []byte, prefix uint8, flags byte, value uint64) []byte {
+	max := uint64(1)<<prefix - 1
+	if value < max {
+		return append(b, flags|byte(value))
+	}
+	b = append(b, flags|byte(max))
+	value -= max
+	for value >= 0x80 {
+		b = append(b, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(b, byte(value))
+}
+
+// readString decodes a string literal (RFC 7541 §5.2)
+func readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, ERROR_HPACK_DECODE
+	}
+	huffman := block[0]&0x80 != 0
+	length, block, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(block)) < length {
+		return "", nil, ERROR_HPACK_DECODE
+	}
+	raw := block[:length]
+	block = block[length:]
+	if !huffman {
+		return string(raw), block, nil
+	}
+	s, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, block, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	if n := huffmanLen(s); n < len(s) {
+		b = appendInt(b, 7, 0x80, uint64(n))
+		return huffmanEncode(b, s)
+	}
+	b = appendInt(b, 7, 0, uint64(len(s)))
+	return append(b, s...)
+}
+
+// Encoder encodes header blocks without a dynamic table, fields are sent
+// as indexed static entries or literals without indexing. That keeps the
+// encoder stateless at the cost of some compression
+type Encoder struct{}
+
+func (e *Encoder) Encode(b []byte, fields []HeaderField) []byte {
+	for _, f := range fields {
+		nameIndex := 0
+		found := false
+		for i, s := range staticTable {
+			if s.Name != f.Name {
+				continue
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+			if s.Value == f.Value {
+				b = appendInt(b, 7, 0x80, uint64(i+1))
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		// sensitive values are never indexed by intermediaries either
+		flags := byte(0)
+		if f.Name == "authorization" || f.Name == "cookie" || f.Name == "set-cookie" {
+			flags = 0x10
+		}
+		b = appendInt(b, 4, flags, uint64(nameIndex))
+		if nameIndex == 0 {
+			b = appendString(b, strings.ToLower(f.Name))
+		}
+		b = appendString(b, f.Value)
+	}
+	return b
+}
@@ -0,0 +1,367 @@
+package http2
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestHPACKDecoder(t *testing.T) {
+	first := []HeaderField{
+		{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+	}
+	second := append(append([]HeaderField{}, first...), HeaderField{"cache-control", "no-cache"})
+	third := []HeaderField{
+		{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"},
+		{":authority", "www.example.com"}, {"custom-key", "custom-value"},
+	}
+
+	// Test: Requests without Huffman coding (RFC 7541 C.3)
+	d := NewDecoder(4096)
+	fields, err := d.Decode(unhex(t, "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"))
+	require.NoError(t, err)
+	assert.Equal(t, first, fields)
+	fields, err = d.Decode(unhex(t, "8286 84be 5808 6e6f 2d63 6163 6865"))
+	require.NoError(t, err)
+	assert.Equal(t, second, fields)
+	fields, err = d.Decode(unhex(t, "8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65"))
+	require.NoError(t, err)
+	assert.Equal(t, third, fields)
+	assert.Equal(t, 164, d.table.size)
+
+	// Test: The same requests with Huffman coding (RFC 7541 C.4)
+	d = NewDecoder(4096)
+	fields, err = d.Decode(unhex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+	require.NoError(t, err)
+	assert.Equal(t, first, fields)
+	fields, err = d.Decode(unhex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+	require.NoError(t, err)
+	assert.Equal(t, second, fields)
+	fields, err = d.Decode(unhex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	require.NoError(t, err)
+	assert.Equal(t, third, fields)
+	assert.Equal(t, 164, d.table.size)
+
+	// Test: Invalid index
+	_, err = NewDecoder(4096).Decode([]byte{0xbe})
+	assert.Equal(t, ERROR_HPACK_INDEX, err)
+
+	// Test: Table size update over the advertised limit
+	_, err = NewDecoder(4096).Decode(unhex(t, "3fe2 1f"))
+	assert.Equal(t, ERROR_HPACK_TABLE_SIZE, err)
+
+	// Test: Header list size limit
+	d = NewDecoder(4096)
+	d.MaxHeaderListSize = 100
+	_, err = d.Decode(unhex(t, "8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d"))
+	assert.Equal(t, ERROR_HEADER_LIST_TOO_LARGE, err)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: Encoding matches RFC 7541 C.4.1
+	assert.Equal(t, unhex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), huffmanEncode(nil, "www.example.com"))
+
+	// Test: Round trip of every byte value
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	decoded, err := huffmanDecode(huffmanEncode(nil, string(all)))
+	require.NoError(t, err)
+	assert.Equal(t, string(all), decoded)
+
+	// Test: Padding that isn't all ones is rejected
+	_, err = huffmanDecode([]byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xfe})
+	assert.Equal(t, ERROR_HPACK_HUFFMAN, err)
+}
+
+func TestHPACKEncoder(t *testing.T) {
+	fields := []HeaderField{
+		{":status", "200"}, {":status", "418"}, {"content-type", "text/plain"},
+		{"set-cookie", "id=1"}, {"x-custom", "value"},
+	}
+
+	// Test: Encoded fields decode to the same list
+	block := (&Encoder{}).Encode(nil, fields)
+	decoded, err := NewDecoder(4096).Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+
+	// Test: A fully matching static entry is a single byte
+	assert.Equal(t, []byte{0x88}, (&Encoder{}).Encode(nil, fields[:1]))
+}
+
+// testClient speaks raw HTTP/2 to a connection served by ServeConn
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	decoder *Decoder
+}
+
+func startConn(t *testing.T, handler Handler, opts *Options) *testClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		ServeConn(conn, nil, handler, opts)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, decoder: NewDecoder(4096)}
+}
+
+func (c *testClient) handshake(settings ...Setting) {
+	c.conn.Write([]byte(Preface))
+	c.writeFrame(FrameSettings, 0, 0, appendSettings(nil, settings...))
+}
+
+func (c *testClient) writeFrame(t FrameType, flags uint8, id uint32, payload []byte) {
+	_, err := c.conn.Write(AppendFrame(nil, t, flags, id, payload))
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) writeHeaders(id uint32, flags uint8, fields ...HeaderField) {
+	c.writeFrame(FrameHeaders, flags|FlagEndHeaders, id, (&Encoder{}).Encode(nil, fields))
+}
+
+// readFrame returns the next frame that isn't part of the connection setup
+func (c *testClient) readFrame() *Frame {
+	for {
+		f, err := ReadFrame(c.conn, maxFrameSizeLimit)
+		require.NoError(c.t, err)
+		if f.Type == FrameSettings || (f.Type == FrameWindowUpdate && f.StreamID == 0) {
+			continue
+		}
+		return f
+	}
+}
+
+// readResponse collects the status, headers and body of a stream
+func (c *testClient) readResponse(id uint32) (map[string]string, string) {
+	fields := map[string]string{}
+	body := ""
+	for {
+		f := c.readFrame()
+		require.Equal(c.t, id, f.StreamID, "unexpected frame %v", f.Type)
+		switch f.Type {
+		case FrameHeaders:
+			decoded, err := c.decoder.Decode(f.Payload)
+			require.NoError(c.t, err)
+			for _, field := range decoded {
+				fields[field.Name] = field.Value
+			}
+		case FrameData:
+			body += string(f.Payload)
+		default:
+			c.t.Fatalf("unexpected frame %v", f.Type)
+		}
+		if f.Has(FlagEndStream) {
+			return fields, body
+		}
+	}
+}
+
+func get(path string) []HeaderField {
+	return []HeaderField{{":method", "GET"}, {":scheme", "http"}, {":path", path}, {":authority", "localhost"}}
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body, err := io.ReadAll(req.BodyReader())
+	if err != nil {
+		return
+	}
+	w.Header().Set("X-Path", req.RequestLine.Path, true)
+	w.Text(response.StatusOK, req.RequestLine.Method+" "+string(body))
+	w.Finish()
+}
+
+func TestServeConn(t *testing.T) {
+	c := startConn(t, echoHandler, nil)
+	c.handshake()
+
+	// Test: A GET without body
+	c.writeHeaders(1, FlagEndStream, get("/hello")...)
+	fields, body := c.readResponse(1)
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "/hello", fields["x-path"])
+	assert.Equal(t, "GET ", body)
+
+	// Test: A body split over DATA frames with padding
+	c.writeHeaders(3, 0, HeaderField{":method", "POST"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/echo"}, HeaderField{":authority", "localhost"})
+	c.writeFrame(FrameData, 0, 3, []byte("hello "))
+	c.writeFrame(FrameData, FlagEndStream|FlagPadded, 3, append([]byte{3}, "world\x00\x00\x00"...))
+	fields, body = c.readResponse(3)
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "POST hello world", body)
+
+	// Test: PING is answered with the same payload
+	c.writeFrame(FramePing, 0, 0, []byte("12345678"))
+	f := c.readFrame()
+	assert.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Has(FlagAck))
+	assert.Equal(t, []byte("12345678"), f.Payload)
+
+	// Test: A malformed request only resets its stream
+	// the encoder lowercases names so the uppercase one is added by hand
+	block := append((&Encoder{}).Encode(nil, get("/")), "\x00\x05Upper\x01x"...)
+	c.writeFrame(FrameHeaders, FlagEndStream|FlagEndHeaders, 5, block)
+	f = c.readFrame()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(5), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload))
+
+	c.writeHeaders(7, FlagEndStream, HeaderField{":method", "GET"}, HeaderField{":path", "/"})
+	f = c.readFrame()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(7), f.StreamID)
+
+	// Test: The connection still serves requests afterwards
+	c.writeHeaders(9, FlagEndStream, get("/again")...)
+	_, body = c.readResponse(9)
+	assert.Equal(t, "GET ", body)
+
+	// Test: DATA on stream 0 is a connection error
+	c.writeFrame(FrameData, 0, 0, []byte("x"))
+	f = c.readFrame()
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, uint32(9), binary.BigEndian.Uint32(f.Payload))
+	assert.Equal(t, uint32(ErrCodeProtocol), binary.BigEndian.Uint32(f.Payload[4:]))
+}
+
+func TestServeConnFlowControl(t *testing.T) {
+	c := startConn(t, func(w *response.Writer, req *request.Request) {
+		w.Text(response.StatusOK, strings.Repeat("a", 25))
+		w.Finish()
+	}, nil)
+
+	// Test: The response stops at the initial window of the client
+	c.handshake(Setting{SettingInitialWindowSize, 10})
+	c.writeHeaders(1, FlagEndStream, get("/")...)
+	f := c.readFrame()
+	assert.Equal(t, FrameHeaders, f.Type)
+	f = c.readFrame()
+	assert.Equal(t, FrameData, f.Type)
+	assert.Len(t, f.Payload, 10)
+
+	// Test: A WINDOW_UPDATE lets the rest through
+	c.writeFrame(FrameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 100))
+	f = c.readFrame()
+	assert.Equal(t, FrameData, f.Type)
+	assert.Len(t, f.Payload, 15)
+	f = c.readFrame()
+	assert.Equal(t, FrameData, f.Type)
+	assert.True(t, f.Has(FlagEndStream))
+}
+
+func TestServeConnConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	c := startConn(t, func(w *response.Writer, req *request.Request) {
+		<-release
+		w.Text(response.StatusOK, req.RequestLine.Path)
+		w.Finish()
+	}, &Options{MaxConcurrentStreams: 1})
+	c.handshake()
+
+	// Test: A stream over MAX_CONCURRENT_STREAMS is refused
+	c.writeHeaders(1, FlagEndStream, get("/first")...)
+	c.writeHeaders(3, FlagEndStream, get("/second")...)
+	f := c.readFrame()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeRefusedStream), binary.BigEndian.Uint32(f.Payload))
+
+	close(release)
+	_, body := c.readResponse(1)
+	assert.Equal(t, "/first", body)
+}
+
+func TestServeConnRapidReset(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	c := startConn(t, func(w *response.Writer, req *request.Request) {
+		<-release
+	}, &Options{MaxConcurrentStreams: 2})
+	c.handshake()
+
+	// Test: A reset stream keeps its slot until the handler returns
+	c.writeHeaders(1, FlagEndStream, get("/")...)
+	c.writeFrame(FrameRSTStream, 0, 1, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+	c.writeHeaders(3, FlagEndStream, get("/")...)
+	c.writeHeaders(5, FlagEndStream, get("/")...)
+	f := c.readFrame()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(5), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeRefusedStream), binary.BigEndian.Uint32(f.Payload))
+
+	// Test: Resetting every stream it opens ends the connection
+	c.writeFrame(FrameRSTStream, 0, 3, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+	f = c.readFrame()
+	assert.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, uint32(ErrCodeEnhanceYourCalm), binary.BigEndian.Uint32(f.Payload[4:]))
+}
+
+func TestServeConnTimeouts(t *testing.T) {
+	bodyErr := make(chan error, 1)
+	c := startConn(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "POST" {
+			_, err := io.ReadAll(req.BodyReader())
+			bodyErr <- err
+			return
+		}
+		w.Text(response.StatusOK, strings.Repeat("a", 25))
+		w.Finish()
+	}, &Options{ReadTimeout: 50 * time.Millisecond, WriteTimeout: 100 * time.Millisecond})
+
+	// Test: A stream whose body stalls is reset
+	c.handshake(Setting{SettingInitialWindowSize, 10})
+	c.writeHeaders(1, 0, HeaderField{":method", "POST"}, HeaderField{":scheme", "http"},
+		HeaderField{":path", "/"}, HeaderField{":authority", "localhost"})
+	c.writeFrame(FrameData, 0, 1, []byte("partial"))
+	f := c.readFrame()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(1), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeCancel), binary.BigEndian.Uint32(f.Payload))
+	assert.Equal(t, ERROR_STREAM_CLOSED, <-bodyErr)
+
+	// Test: A stream the client never grants window to is reset
+	c.writeHeaders(3, FlagEndStream, get("/")...)
+	f = c.readFrame()
+	assert.Equal(t, FrameHeaders, f.Type)
+	f = c.readFrame()
+	assert.Equal(t, FrameData, f.Type)
+	assert.Len(t, f.Payload, 10)
+	f = c.readFrame()
+	assert.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(3), f.StreamID)
+	assert.Equal(t, uint32(ErrCodeCancel), binary.BigEndian.Uint32(f.Payload))
+
+	// Test: The connection still serves requests afterwards
+	c.writeFrame(FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, 100))
+	c.writeHeaders(5, FlagEndStream, get("/")...)
+	f = c.readFrame()
+	assert.Equal(t, FrameHeaders, f.Type)
+	assert.Equal(t, uint32(5), f.StreamID)
+}
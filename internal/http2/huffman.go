@@ -0,0 +1,88 @@
+package http2
+
+// huffmanNode is a node of the decoding tree, leaves hold a symbol
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   byte
+	leaf     bool
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		n := root
+		for i := int(huffmanCodeLens[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{}
+			}
+			n = n.children[bit]
+		}
+		n.leaf = true
+		n.symbol = byte(sym)
+	}
+	return root
+}
+
+// huffmanDecode decodes a Huffman coded string, the padding must be at
+// most 7 bits of the EOS prefix (all ones) as required by RFC 7541 §5.2
+func huffmanDecode(b []byte) (string, error) {
+	out := make([]byte, 0, len(b)*8/5)
+	n := huffmanRoot
+	// padding tracks the bits read since the last symbol and whether
+	// they were all ones
+	padding := 0
+	allOnes := true
+	for _, c := range b {
+		for i := 7; i >= 0; i-- {
+			bit := (c >> uint(i)) & 1
+			n = n.children[bit]
+			if n == nil {
+				// only EOS is missing from the tree, it must not be decoded
+				return "", ERROR_HPACK_HUFFMAN
+			}
+			padding++
+			allOnes = allOnes && bit == 1
+			if n.leaf {
+				out = append(out, n.symbol)
+				n = huffmanRoot
+				padding = 0
+				allOnes = true
+			}
+		}
+	}
+	if padding > 7 || !allOnes {
+		return "", ERROR_HPACK_HUFFMAN
+	}
+	return string(out), nil
+}
+
+func huffmanLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLens[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(b []byte, s string) []byte {
+	var acc uint64
+	bits := uint(0)
+	for i := 0; i < len(s); i++ {
+		length := uint(huffmanCodeLens[s[i]])
+		acc = acc<<length | uint64(huffmanCodes[s[i]])
+		bits += length
+		for bits >= 8 {
+			bits -= 8
+			b = append(b, byte(acc>>bits))
+		}
+	}
+	if bits > 0 {
+		// pad with the most significant bits of EOS, all ones
+		acc = acc<<(8-bits) | (1<<(8-bits) - 1)
+		b = append(b, byte(acc))
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+package http2
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"tcpToHttp/internal/request"
+)
+
+var ERROR_NOT_UPGRADE = fmt.Errorf("http2: not an h2c upgrade request.")
+
+// UpgradeRequested reports whether req asks to switch to h2c with a valid
+// HTTP2-Settings field (RFC 7540 §3.2). Requests with a body are answered
+// over HTTP/1.1 instead since the body would have to be read first
+func UpgradeRequested(req *request.Request) bool {
+	_, err := upgradeSettings(req)
+	return err == nil
+}
+
+func upgradeSettings(req *request.Request) ([]Setting, error) {
+	h := req.Headers
+	if req.RequestLine.IsHTTP10() || !h.HasToken("Upgrade", "h2c") ||
+		!h.HasToken("Connection", "Upgrade") || !h.HasToken("Connection", "HTTP2-Settings") {
+		return nil, ERROR_NOT_UPGRADE
+	}
+	if _, ok := h.Get("Transfer-Encoding"); ok || h.GetInt("Content-Length", 0) != 0 {
+		return nil, ERROR_NOT_UPGRADE
+	}
+
+	values := h.Values("HTTP2-Settings")
+	if len(values) != 1 {
+		return nil, ERROR_NOT_UPGRADE
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(values[0], "="))
+	if err != nil {
+		return nil, ERROR_NOT_UPGRADE
+	}
+	return parseSettings(payload)
+}
+
+// ServeUpgrade answers an h2c upgrade request with 101 Switching Protocols
+// and serves the connection over HTTP/2, the response to req goes out on
+// stream 1. It returns once the connection is closed
+func ServeUpgrade(conn net.Conn, req *request.Request, handler Handler, opts *Options) error {
+	settings, err := upgradeSettings(req)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+		req.Headers.Delete(name)
+	}
+
+	sc := newServerConn(conn, req.Buffered(), handler, opts)
+	// the 101 acknowledges the settings, no SETTINGS ack is sent for them
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	if err := sc.write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")); err != nil {
+		return err
+	}
+	return sc.serve(req)
+}
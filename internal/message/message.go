@@ -0,0 +1,199 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/chunked"
+)
+
+var ERROR_HEAD_TOO_LARGE = fmt.Errorf("message head too large.")
+
+// MaxHeadSize caps the start line and headers of a message together, the
+// buffer would otherwise grow for as long as the peer keeps sending
+const MaxHeadSize = 64 << 10
+
+// ParseContentLength parses a Content-Length value strictly, only digits
+// are accepted and a list only when all its values are the same (RFC 9110
+// §8.6). A lax parse would let a body be read as the next message
+func ParseContentLength(value string) (int64, bool) {
+	var length int64 = -1
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || length >= 0 && n != length {
+			return 0, false
+		}
+		length = n
+	}
+	return length, true
+}
+
+// Reader feeds the bytes of a connection to an incremental parser of
+// requests or responses. The parser is handed whatever is buffered and
+// reports how much of it was used, so nothing past the end of a message is
+// consumed and the rest stays buffered for the next one
+type Reader struct {
+	src     io.Reader
+	buf     []byte
+	n       int
+	headLen int
+}
+
+// NewReader reads from src, buffered holds bytes already read from it
+func NewReader(src io.Reader, buffered []byte) *Reader {
+	buf := make([]byte, max(1024, 2*len(buffered)))
+	return &Reader{
+		src: src,
+		buf: buf,
+		n:   copy(buf, buffered),
+	}
+}
+
+// ReadUntil hands the buffered bytes to parse and reads more from the
+// connection until stop returns true. While inHead returns true the bytes
+// go against MaxHeadSize. Errors of the connection, io.EOF included, are
+// returned as is
+func (r *Reader) ReadUntil(parse func(data []byte) (int, error), inHead, stop func() bool) error {
+	for !stop() {
+		wasHead := inHead()
+		readN, err := parse(r.buf[:r.n])
+		if err != nil {
+			return err
+		}
+		r.discard(readN)
+		// without progress everything buffered belongs to an unfinished line
+		if wasHead {
+			r.headLen += readN
+			if r.headLen > MaxHeadSize || readN == 0 && r.headLen+r.n > MaxHeadSize {
+				return ERROR_HEAD_TOO_LARGE
+			}
+		}
+		if readN > 0 {
+			continue
+		}
+
+		if err := r.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBody streams the next piece of b into p, the buffered bytes come
+// first. It returns io.EOF once the body is done and io.ErrUnexpectedEOF
+// when the connection ends before
+func (r *Reader) ReadBody(p []byte, b *Body) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for !b.Done() {
+		n, piece, err := b.Next(r.buf[:r.n], len(p))
+		if err != nil {
+			return 0, err
+		}
+		copied := copy(p, piece)
+		r.discard(n)
+		if copied > 0 {
+			return copied, nil
+		}
+		if n > 0 {
+			continue
+		}
+
+		// the buffered bytes end in the middle of the framing
+		if err := r.fill(); err != nil {
+			if err == io.EOF && b.UntilEOF {
+				b.End()
+				continue
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	return 0, io.EOF
+}
+
+// Buffered returns the bytes read from the connection but not parsed yet
+func (r *Reader) Buffered() []byte {
+	return bytes.Clone(r.buf[:r.n])
+}
+
+func (r *Reader) discard(n int) {
+	copy(r.buf, r.buf[n:r.n])
+	r.n -= n
+}
+
+// fill reads once from the connection, growing the buffer when it is full
+func (r *Reader) fill() error {
+	if r.n == len(r.buf) {
+		r.buf = append(r.buf, make([]byte, len(r.buf))...)
+	}
+	n, err := r.src.Read(r.buf[r.n:])
+	r.n += n
+	if n == 0 && err != nil {
+		return err
+	}
+	return nil
+}
+
+// Body tracks how a body is delimited, by a length, by chunks or by the
+// end of the connection (RFC 9112 §6.3), and how much of it was decoded
+type Body struct {
+	// Length is the length of a body that isn't chunked or UntilEOF
+	Length int64
+	// Chunked decodes a chunked body, its Trailers are complete once the
+	// body is done
+	Chunked *chunked.Decoder
+	// UntilEOF is set when the body ends with the connection
+	UntilEOF bool
+	// Read counts the decoded body bytes
+	Read int64
+	eof  bool
+}
+
+// Next decodes the next piece of body in data, at most max bytes of it.
+// It returns how much of data was used and the body bytes within it
+func (b *Body) Next(data []byte, max int) (int, []byte, error) {
+	n := 0
+	var piece []byte
+	switch {
+	case b.Chunked != nil:
+		var err error
+		n, piece, err = b.Chunked.Next(data, max)
+		if err != nil {
+			return 0, nil, err
+		}
+	case b.UntilEOF:
+		n = min(len(data), max)
+		piece = data[:n]
+	default:
+		n = int(min(int64(len(data)), int64(max), b.Length-b.Read))
+		piece = data[:n]
+	}
+	b.Read += int64(len(piece))
+	return n, piece, nil
+}
+
+// Done reports whether the whole body was decoded
+func (b *Body) Done() bool {
+	switch {
+	case b.Chunked != nil:
+		return b.Chunked.Done()
+	case b.UntilEOF:
+		return b.eof
+	}
+	return b.Read >= b.Length
+}
+
+// End marks a body that ends with the connection as done
+func (b *Body) End() {
+	b.eof = true
+}
@@ -0,0 +1,126 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"tcpToHttp/internal/chunked"
+	"tcpToHttp/internal/testutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lineParser consumes whole lines until an empty one, like a head
+type lineParser struct {
+	lines []string
+	done  bool
+}
+
+func (lp *lineParser) parse(data []byte) (int, error) {
+	read := 0
+	for !lp.done {
+		idx := bytes.Index(data[read:], []byte("\r\n"))
+		if idx == -1 {
+			break
+		}
+		if idx == 0 {
+			lp.done = true
+		} else {
+			lp.lines = append(lp.lines, string(data[read:read+idx]))
+		}
+		read += idx + 2
+	}
+	return read, nil
+}
+
+func TestReaderReadUntil(t *testing.T) {
+	// Test: Parsing stops at the end of the head, the rest stays buffered
+	lp := &lineParser{}
+	r := NewReader(&testutil.ChunkReader{Data: "b\r\nc\r\n\r\nbody", NumBytesPerRead: 64}, []byte("a\r\n"))
+	inHead := func() bool { return !lp.done }
+	done := func() bool { return lp.done }
+	require.NoError(t, r.ReadUntil(lp.parse, inHead, done))
+	assert.Equal(t, []string{"a", "b", "c"}, lp.lines)
+	assert.Equal(t, "body", string(r.Buffered()))
+
+	// Test: A line that never ends
+	lp = &lineParser{}
+	r = NewReader(&testutil.ChunkReader{Data: strings.Repeat("a", 100_000), NumBytesPerRead: 1024}, nil)
+	assert.Equal(t, ERROR_HEAD_TOO_LARGE, r.ReadUntil(lp.parse, inHead, done))
+
+	// Test: Many lines over the limit that arrive at once
+	lp = &lineParser{}
+	head := strings.Repeat("aaaaaaaaaaaaaaaaaaaa\r\n", 4000) + "\r\n"
+	r = NewReader(strings.NewReader(""), []byte(head))
+	assert.Equal(t, ERROR_HEAD_TOO_LARGE, r.ReadUntil(lp.parse, inHead, done))
+
+	// Test: The end of the connection is returned as is
+	lp = &lineParser{}
+	r = NewReader(&testutil.ChunkReader{Data: "a\r\n", NumBytesPerRead: 1}, nil)
+	assert.Equal(t, io.EOF, r.ReadUntil(lp.parse, inHead, done))
+}
+
+func TestReaderReadBody(t *testing.T) {
+	readAll := func(r *Reader, b *Body) (string, error) {
+		out := []byte{}
+		p := make([]byte, 3)
+		for {
+			n, err := r.ReadBody(p, b)
+			out = append(out, p[:n]...)
+			if err == io.EOF {
+				return string(out), nil
+			}
+			if err != nil {
+				return string(out), err
+			}
+		}
+	}
+
+	// Test: A body with a length leaves the next message buffered
+	r := NewReader(&testutil.ChunkReader{Data: "hello worldnext", NumBytesPerRead: 64}, nil)
+	body, err := readAll(r, &Body{Length: 11})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", body)
+	assert.Equal(t, "next", string(r.Buffered()))
+
+	// Test: A chunked body, partly buffered already
+	b := &Body{Chunked: chunked.NewDecoder()}
+	r = NewReader(&testutil.ChunkReader{Data: "ki\r\n5\r\npedia\r\n0\r\nX-Sum: 1\r\n\r\n", NumBytesPerRead: 1}, []byte("4\r\nwi"))
+	body, err = readAll(r, b)
+	require.NoError(t, err)
+	assert.Equal(t, "wikipedia", body)
+	assert.Equal(t, int64(9), b.Read)
+	sum, _ := b.Chunked.Trailers.Get("X-Sum")
+	assert.Equal(t, "1", sum)
+
+	// Test: A body until the end of the connection
+	b = &Body{UntilEOF: true}
+	r = NewReader(&testutil.ChunkReader{Data: "until the end", NumBytesPerRead: 4}, nil)
+	body, err = readAll(r, b)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", body)
+	assert.True(t, b.Done())
+
+	// Test: The connection ends before the body
+	r = NewReader(&testutil.ChunkReader{Data: "short", NumBytesPerRead: 2}, nil)
+	body, err = readAll(r, &Body{Length: 10})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, "short", body)
+}
+
+func TestParseContentLength(t *testing.T) {
+	// Test: Plain numbers and lists of the same value
+	for value, want := range map[string]int64{"0": 0, "42": 42, "5, 5": 5, " 7 ,7": 7} {
+		length, ok := ParseContentLength(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, want, length, value)
+	}
+
+	// Test: Anything else
+	for _, value := range []string{"", "abc", "-1", "+1", "1.0", "5, 6", "5,", "99999999999999999999"} {
+		_, ok := ParseContentLength(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -0,0 +1,215 @@
+package multipart
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"tcpToHttp/internal/headers"
+)
+
+var ERROR_MALFORMED_MULTIPART = fmt.Errorf("malformed multipart body.")
+var ERROR_PART_HEADERS_TOO_LARGE = fmt.Errorf("multipart part headers too large.")
+var ERROR_PART_TOO_LARGE = fmt.Errorf("multipart part too large.")
+var ERROR_MULTIPART_TOO_LARGE = fmt.Errorf("multipart body too large.")
+
+const maxHeaderSize = 16 << 10
+
+// Reader streams the parts of a multipart body (RFC 2046 §5.1, RFC 7578),
+// a part has to be consumed before the next one is returned
+type Reader struct {
+	br *bufio.Reader
+	// dashBoundary is "--boundary" and delim is "\r\n--boundary"
+	dashBoundary []byte
+	delim        []byte
+
+	// MaxPartSize and MaxTotalSize limit the body bytes of a single part and
+	// of all the parts together, 0 means unlimited
+	MaxPartSize  int64
+	MaxTotalSize int64
+	total        int64
+
+	current *Part
+	started bool
+	done    bool
+}
+
+func NewReader(r io.Reader, boundary string) *Reader {
+	return &Reader{
+		br:           bufio.NewReaderSize(r, 4096+len(boundary)),
+		dashBoundary: []byte("--" + boundary),
+		delim:        []byte("\r\n--" + boundary),
+	}
+}
+
+type Part struct {
+	Headers *headers.Headers
+
+	r           *Reader
+	read        int64
+	done        bool
+	disposition string
+	params      map[string]string
+}
+
+// NextPart skips the rest of the current part and returns the next one,
+// io.EOF is returned after the closing delimiter
+func (r *Reader) NextPart() (*Part, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	if r.current != nil {
+		if _, err := io.Copy(io.Discard, r.current); err != nil {
+			return nil, err
+		}
+		r.current = nil
+	}
+
+	if !r.started {
+		if err := r.skipPreamble(); err != nil {
+			return nil, err
+		}
+		r.started = true
+	} else {
+		if _, err := r.br.Discard(len(r.delim)); err != nil {
+			return nil, ERROR_MALFORMED_MULTIPART
+		}
+		line, err := r.br.ReadSlice('\n')
+		if err != nil && !(err == io.EOF && bytes.HasPrefix(line, []byte("--"))) {
+			return nil, ERROR_MALFORMED_MULTIPART
+		}
+		if bytes.HasPrefix(line, []byte("--")) {
+			r.done = true
+			return nil, io.EOF
+		}
+		if len(bytes.TrimRight(line, " \t\r\n")) != 0 {
+			return nil, ERROR_MALFORMED_MULTIPART
+		}
+	}
+
+	h, err := r.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+	part := &Part{
+		Headers: h,
+		r:       r,
+	}
+	if value, ok := h.Get("Content-Disposition"); ok {
+		part.disposition, part.params, _ = headers.ParseParams(value)
+	}
+	r.current = part
+	return part, nil
+}
+
+// skipPreamble discards everything up to the first delimiter line
+func (r *Reader) skipPreamble() error {
+	for {
+		line, err := r.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// a long preamble line can't be a delimiter, skip the rest of it
+			for err == bufio.ErrBufferFull {
+				_, err = r.br.ReadSlice('\n')
+			}
+			continue
+		}
+		if err != nil {
+			return ERROR_MALFORMED_MULTIPART
+		}
+
+		line = bytes.TrimRight(line, " \t\r\n")
+		if !bytes.HasPrefix(line, r.dashBoundary) {
+			continue
+		}
+		switch string(line[len(r.dashBoundary):]) {
+		case "":
+			return nil
+		case "--":
+			r.done = true
+			return io.EOF
+		}
+	}
+}
+
+func (r *Reader) readHeaders() (*headers.Headers, error) {
+	h := headers.NewHeaders()
+	size := 0
+	for {
+		line, err := r.br.ReadSlice('\n')
+		size += len(line)
+		if size > maxHeaderSize || err == bufio.ErrBufferFull {
+			return nil, ERROR_PART_HEADERS_TOO_LARGE
+		}
+		if err != nil {
+			return nil, ERROR_MALFORMED_MULTIPART
+		}
+
+		_, done, err := h.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// Read reads the body of the part up to the next delimiter
+func (p *Part) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+	r := p.r
+
+	// peek at least a whole delimiter so a match is never missed
+	peek, err := r.br.Peek(max(len(r.delim), r.br.Buffered()))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	limit := len(peek)
+	if idx := bytes.Index(peek, r.delim); idx != -1 {
+		limit = idx
+		if limit == 0 {
+			p.done = true
+			return 0, io.EOF
+		}
+	} else if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else {
+		// the tail might be the start of a delimiter
+		limit = len(peek) - len(r.delim) + 1
+	}
+
+	n := copy(b, peek[:limit])
+	if r.MaxPartSize > 0 && p.read+int64(n) > r.MaxPartSize {
+		return 0, ERROR_PART_TOO_LARGE
+	}
+	if r.MaxTotalSize > 0 && r.total+int64(n) > r.MaxTotalSize {
+		return 0, ERROR_MULTIPART_TOO_LARGE
+	}
+	r.br.Discard(n)
+	p.read += int64(n)
+	r.total += int64(n)
+	return n, nil
+}
+
+// FormName returns the name parameter of a form-data Content-Disposition
+func (p *Part) FormName() string {
+	if p.disposition != "form-data" {
+		return ""
+	}
+	return p.params["name"]
+}
+
+// FileName returns the filename parameter of the Content-Disposition,
+// only the last path element is kept
+func (p *Part) FileName() string {
+	name := p.params["filename"]
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
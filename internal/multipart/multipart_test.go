@@ -0,0 +1,103 @@
+package multipart
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readParts(t *testing.T, r *Reader) map[string]string {
+	parts := map[string]string{}
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts[part.FormName()] = string(data)
+	}
+}
+
+func TestReader(t *testing.T) {
+	body := "preamble to ignore\r\n" +
+		"--b0undary\r\n" +
+		"Content-Disposition: form-data; name=\"a\"\r\n\r\n" +
+		"\r\n--b0undar not a delimiter\r\n" +
+		"--b0undary  \r\n" +
+		"Content-Disposition: form-data; name=\"empty\"\r\n\r\n" +
+		"\r\n--b0undary\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"C:\\\\dir\\\\photo.jpg\"\r\n" +
+		"Content-Type: image/jpeg\r\n\r\n" +
+		strings.Repeat("x", 10000) + "\r\n" +
+		"--b0undary--\r\n" +
+		"epilogue"
+
+	// Test: One byte at a time
+	r := NewReader(iotest.OneByteReader(strings.NewReader(body)), "b0undary")
+	parts := readParts(t, r)
+	assert.Equal(t, "\r\n--b0undar not a delimiter", parts["a"])
+	assert.Equal(t, "", parts["empty"])
+	assert.Equal(t, strings.Repeat("x", 10000), parts["upload"])
+
+	// Test: Unread parts are skipped and headers are exposed
+	r = NewReader(strings.NewReader(body), "b0undary")
+	_, err := r.NextPart()
+	require.NoError(t, err)
+	_, err = r.NextPart()
+	require.NoError(t, err)
+	part, err := r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "photo.jpg", part.FileName())
+	contentType, _ := part.Headers.Get("Content-Type")
+	assert.Equal(t, "image/jpeg", contentType)
+	_, err = r.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Part and total size limits
+	r = NewReader(strings.NewReader(body), "b0undary")
+	r.MaxPartSize = 100
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	require.NoError(t, err)
+	_, err = r.NextPart()
+	require.NoError(t, err)
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.Equal(t, ERROR_PART_TOO_LARGE, err)
+
+	r = NewReader(strings.NewReader(body), "b0undary")
+	r.MaxTotalSize = 5000
+	_, err = r.NextPart()
+	require.NoError(t, err)
+	_, err = r.NextPart()
+	require.NoError(t, err)
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.Equal(t, ERROR_MULTIPART_TOO_LARGE, err)
+
+	// Test: Truncated body
+	r = NewReader(strings.NewReader("--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nabc"), "b")
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Test: Missing delimiter
+	r = NewReader(strings.NewReader("no parts here"), "b")
+	_, err = r.NextPart()
+	assert.Equal(t, ERROR_MALFORMED_MULTIPART, err)
+
+	// Test: Empty multipart body
+	r = NewReader(strings.NewReader("--b--\r\n"), "b")
+	_, err = r.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
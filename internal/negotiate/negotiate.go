@@ -0,0 +1,253 @@
+package negotiate
+
+import (
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/headers"
+)
+
+// Spec is one element of a quality-value list like `text/html;level=1;q=0.8`
+type Spec struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// ParseList parses a comma separated list with optional q-values as used
+// by Accept, Accept-Language, Accept-Charset and Accept-Encoding
+// (RFC 9110 §12.4.2), elements with a malformed q-value are dropped
+func ParseList(value string) []Spec {
+	specs := []Spec{}
+	for _, element := range splitList(value) {
+		if element == "" {
+			continue
+		}
+		// q separates the media type parameters from accept extensions,
+		// which are dropped
+		before, qvalue, hasQ := cutQ(element)
+		v, params, err := headers.ParseParams(before)
+		if err != nil {
+			continue
+		}
+
+		spec := Spec{Value: v, Q: 1, Params: params}
+		if hasQ {
+			q, ok := parseQ(qvalue)
+			if !ok {
+				continue
+			}
+			spec.Q = q
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// splitList splits on commas outside of quoted strings
+func splitList(value string) []string {
+	parts := []string{}
+	quoted := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, strings.TrimSpace(value[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+// cutQ finds the q parameter of an element and returns the part before it
+func cutQ(element string) (string, string, bool) {
+	parts := strings.Split(element, ";")
+	for i, part := range parts[1:] {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+			return strings.Join(parts[:i+1], ";"), strings.TrimSpace(value), true
+		}
+	}
+	return element, "", false
+}
+
+// parseQ parses a qvalue, 0 to 1 with at most three decimals
+func parseQ(value string) (float64, bool) {
+	if value == "" || len(value) > 5 || (value[0] != '0' && value[0] != '1') {
+		return 0, false
+	}
+	if len(value) > 1 && value[1] != '.' {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// match is the q-value a list gives to an offer, specificity tells which
+// of several matching elements applies
+type match struct {
+	q           float64
+	specificity int
+	found       bool
+}
+
+func (m *match) update(q float64, specificity int) {
+	if !m.found || specificity > m.specificity {
+		m.q = q
+		m.specificity = specificity
+		m.found = true
+	}
+}
+
+// best returns the offer with the highest q-value above 0, earlier offers
+// win ties so they should be listed in the order the server prefers them
+func best(offers []string, score func(offer string) (float64, bool)) (string, bool) {
+	chosen := ""
+	chosenQ := -1.0
+	for _, offer := range offers {
+		q, ok := score(offer)
+		if ok && q > chosenQ {
+			chosen = offer
+			chosenQ = q
+		}
+	}
+	return chosen, chosenQ >= 0
+}
+
+// MediaType picks one of the offered media types based on Accept, more
+// specific media ranges take precedence (RFC 9110 §12.5.1). Without an
+// Accept header the first offer is returned
+func MediaType(h *headers.Headers, offers ...string) (string, bool) {
+	accept, ok := h.Get("Accept")
+	if !ok || len(offers) == 0 {
+		return first(offers)
+	}
+
+	specs := ParseList(accept)
+	return best(offers, func(offer string) (float64, bool) {
+		offerType, offerParams, err := headers.ParseParams(offer)
+		if err != nil {
+			return 0, false
+		}
+		mainType, subType, _ := strings.Cut(offerType, "/")
+
+		m := match{}
+		for _, spec := range specs {
+			rangeType, rangeSub, _ := strings.Cut(spec.Value, "/")
+			specificity := 0
+			switch {
+			case spec.Value == "*/*":
+				specificity = 1
+			case rangeType == mainType && rangeSub == "*":
+				specificity = 2
+			case rangeType == mainType && rangeSub == subType:
+				specificity = 3 + len(spec.Params)
+			default:
+				continue
+			}
+			if !paramsMatch(spec.Params, offerParams) {
+				continue
+			}
+			m.update(spec.Q, specificity)
+		}
+		return m.q, m.found && m.q > 0
+	})
+}
+
+func paramsMatch(want, have map[string]string) bool {
+	for k, v := range want {
+		if !strings.EqualFold(have[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Language picks one of the offered language tags based on Accept-Language
+// using basic filtering (RFC 4647 §3.3.1), "en" matches "en-US" but not
+// the other way around
+func Language(h *headers.Headers, offers ...string) (string, bool) {
+	accept, ok := h.Get("Accept-Language")
+	if !ok || len(offers) == 0 {
+		return first(offers)
+	}
+
+	specs := ParseList(accept)
+	return best(offers, func(offer string) (float64, bool) {
+		tag := strings.ToLower(offer)
+		m := match{}
+		for _, spec := range specs {
+			switch {
+			case spec.Value == "*":
+				m.update(spec.Q, 0)
+			case spec.Value == tag || strings.HasPrefix(tag, spec.Value+"-"):
+				m.update(spec.Q, len(spec.Value))
+			}
+		}
+		return m.q, m.found && m.q > 0
+	})
+}
+
+// Charset picks one of the offered charsets based on Accept-Charset
+func Charset(h *headers.Headers, offers ...string) (string, bool) {
+	accept, ok := h.Get("Accept-Charset")
+	if !ok || len(offers) == 0 {
+		return first(offers)
+	}
+	return token(ParseList(accept), offers)
+}
+
+// Encoding picks one of the offered content codings based on
+// Accept-Encoding. "identity" stays acceptable unless excluded with q=0
+// (RFC 9110 §12.5.3) so it should be offered last as the fallback
+func Encoding(h *headers.Headers, offers ...string) (string, bool) {
+	accept, ok := h.Get("Accept-Encoding")
+	if !ok || len(offers) == 0 {
+		return first(offers)
+	}
+
+	specs := ParseList(accept)
+	for i := range specs {
+		if specs[i].Value == "x-gzip" {
+			specs[i].Value = "gzip"
+		}
+	}
+	return token(specs, offers)
+}
+
+// token matches offers against a list of case-insensitive tokens and "*"
+func token(specs []Spec, offers []string) (string, bool) {
+	return best(offers, func(offer string) (float64, bool) {
+		value := strings.ToLower(offer)
+		m := match{}
+		for _, spec := range specs {
+			switch spec.Value {
+			case "*":
+				m.update(spec.Q, 0)
+			case value:
+				m.update(spec.Q, 1)
+			}
+		}
+		if !m.found && value == "identity" {
+			return 0, true
+		}
+		return m.q, m.found && m.q > 0
+	})
+}
+
+func first(offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+	return offers[0], true
+}
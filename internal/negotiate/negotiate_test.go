@@ -0,0 +1,108 @@
+package negotiate
+
+import (
+	"tcpToHttp/internal/headers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withHeader(name, value string) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Set(name, value, true)
+	return h
+}
+
+func TestParseList(t *testing.T) {
+	// Test: Q-values, parameters and accept extensions
+	specs := ParseList(`text/html;level=1;q=0.5;ext="a,b", application/json, */*;q=0`)
+	assert.Equal(t, []Spec{
+		{Value: "text/html", Q: 0.5, Params: map[string]string{"level": "1"}},
+		{Value: "application/json", Q: 1, Params: map[string]string{}},
+		{Value: "*/*", Q: 0, Params: map[string]string{}},
+	}, specs)
+
+	// Test: Malformed q-values are dropped
+	specs = ParseList("gzip;q=2, br;q=0.1234, deflate;q=abc, identity;q=0.001")
+	assert.Equal(t, []Spec{{Value: "identity", Q: 0.001, Params: map[string]string{}}}, specs)
+}
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+		ok     bool
+	}{
+		// Test: Highest q-value wins
+		{"text/html;q=0.8, application/json", []string{"text/html", "application/json"}, "application/json", true},
+		// Test: Server order breaks ties
+		{"*/*", []string{"text/html", "application/json"}, "text/html", true},
+		// Test: More specific ranges take precedence
+		{"text/*;q=0.3, text/html;q=0.7, text/html;level=1, */*;q=0.5", []string{"text/html;level=1", "text/plain", "image/png", "text/html"}, "text/html;level=1", true},
+		{"text/*;q=0.3, text/html;q=0.7, */*;q=0.5", []string{"text/plain", "image/png"}, "image/png", true},
+		// Test: Excluded with q=0
+		{"application/json, text/html;q=0", []string{"text/html"}, "", false},
+		// Test: Nothing matches
+		{"image/*", []string{"text/html", "application/json"}, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := MediaType(withHeader("Accept", tt.accept), tt.offers...)
+		assert.Equal(t, tt.ok, ok, tt.accept)
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+
+	// Test: No Accept header accepts anything
+	got, ok := MediaType(headers.NewHeaders(), "application/json", "text/html")
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", got)
+}
+
+func TestLanguage(t *testing.T) {
+	h := withHeader("Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.1")
+	got, ok := Language(h, "en-US", "fr")
+	assert.True(t, ok)
+	assert.Equal(t, "fr", got)
+
+	// Test: A range matches longer tags, not shorter ones
+	h = withHeader("Accept-Language", "en-GB, de;q=0.5")
+	got, ok = Language(h, "en", "de-AT")
+	assert.True(t, ok)
+	assert.Equal(t, "de-AT", got)
+
+	_, ok = Language(withHeader("Accept-Language", "ja"), "en", "de")
+	assert.False(t, ok)
+}
+
+func TestCharset(t *testing.T) {
+	got, ok := Charset(withHeader("Accept-Charset", "iso-8859-5, UTF-8;q=0.8"), "utf-8", "iso-8859-5")
+	assert.True(t, ok)
+	assert.Equal(t, "iso-8859-5", got)
+
+	_, ok = Charset(withHeader("Accept-Charset", "utf-8, *;q=0"), "iso-8859-1")
+	assert.False(t, ok)
+}
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"gzip, deflate", "gzip", true},
+		{"deflate, gzip;q=0.5", "deflate", true},
+		{"x-gzip", "gzip", true},
+		{"*", "gzip", true},
+		// Test: identity stays acceptable unless excluded
+		{"br", "identity", true},
+		{"", "identity", true},
+		{"br, identity;q=0", "", false},
+		{"br, *;q=0", "", false},
+		{"gzip;q=0, *", "deflate", true},
+	}
+	for _, tt := range tests {
+		got, ok := Encoding(withHeader("Accept-Encoding", tt.accept), "gzip", "deflate", "identity")
+		assert.Equal(t, tt.ok, ok, tt.accept)
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+}
@@ -0,0 +1,61 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ERROR_INVALID_ESCAPE = fmt.Errorf("invalid percent-encoding.")
+
+func unhex(ch byte) (byte, bool) {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return ch - '0', true
+	case ch >= 'a' && ch <= 'f':
+		return ch - 'a' + 10, true
+	case ch >= 'A' && ch <= 'F':
+		return ch - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// PathUnescape decodes %XX sequences in a path, "+" is kept as is
+func PathUnescape(s string) (string, error) {
+	return unescape(s, false)
+}
+
+// QueryUnescape decodes %XX sequences and "+" as space in a query or
+// urlencoded form component
+func QueryUnescape(s string) (string, error) {
+	return unescape(s, true)
+}
+
+func unescape(s string, plus bool) (string, error) {
+	if !strings.Contains(s, "%") && (!plus || !strings.Contains(s, "+")) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '+' && plus {
+			b.WriteByte(' ')
+			continue
+		}
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", ERROR_INVALID_ESCAPE
+		}
+		hi, ok1 := unhex(s[i+1])
+		lo, ok2 := unhex(s[i+2])
+		if !ok1 || !ok2 {
+			return "", ERROR_INVALID_ESCAPE
+		}
+		b.WriteByte(hi<<4 | lo)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,137 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	h "tcpToHttp/internal/headers"
+	"tcpToHttp/internal/multipart"
+)
+
+var ERROR_FORM_TOO_LARGE = fmt.Errorf("form body too large.")
+var ERROR_NOT_MULTIPART = fmt.Errorf("request body is not multipart/form-data.")
+
+const DefaultMaxFormSize = 10 << 20
+
+// Values maps a form or query key to its values in order of appearance
+type Values map[string][]string
+
+// Get returns the first value for the key
+func (v Values) Get(key string) string {
+	if len(v[key]) == 0 {
+		return ""
+	}
+	return v[key][0]
+}
+
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// ParseQuery parses an urlencoded string like "a=1&b=2", malformed pairs
+// are skipped and the first error is returned with the parsed values
+func ParseQuery(query string) (Values, error) {
+	values := Values{}
+	var firstErr error
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := QueryUnescape(key)
+		if err == nil {
+			value, err = QueryUnescape(value)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		values.Add(key, value)
+	}
+	return values, firstErr
+}
+
+// Query returns the values of the query string, malformed pairs are skipped
+func (r *Request) Query() Values {
+	values, _ := ParseQuery(r.RequestLine.RawQuery)
+	return values
+}
+
+func (r *Request) mediaType() string {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, _, _ := h.ParseParams(contentType)
+	return mediaType
+}
+
+// ParseForm fills r.PostForm with an application/x-www-form-urlencoded body
+// and r.Form with the body values followed by the query ones
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	r.PostForm = Values{}
+	if r.mediaType() == "application/x-www-form-urlencoded" {
+		maxSize := r.MaxFormSize
+		if maxSize == 0 {
+			maxSize = DefaultMaxFormSize
+		}
+		if r.Headers.GetInt("Content-Length", 0) > maxSize {
+			return ERROR_FORM_TOO_LARGE
+		}
+		// a chunked body has no length up front so it is cut off while reading
+		formLimit := r.maxBodySize == 0 || r.maxBodySize > maxSize
+		if formLimit {
+			r.SetMaxBodySize(maxSize)
+		}
+
+		body, err := r.ReadBody()
+		if errors.Is(err, ERROR_BODY_TOO_LARGE) && formLimit {
+			return ERROR_FORM_TOO_LARGE
+		}
+		if err != nil {
+			return err
+		}
+		// the body may have been read before under a larger limit
+		if len(body) > maxSize {
+			return ERROR_FORM_TOO_LARGE
+		}
+		r.PostForm, err = ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+	}
+
+	r.Form = Values{}
+	for k, v := range r.PostForm {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	for k, v := range r.Query() {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	return nil
+}
+
+// FormValue returns the first value for the key from the body or the query
+func (r *Request) FormValue(key string) string {
+	r.ParseForm()
+	return r.Form.Get(key)
+}
+
+// MultipartReader streams the parts of a multipart/form-data body
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, params, err := h.ParseParams(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, ERROR_NOT_MULTIPART
+	}
+	return multipart.NewReader(r.BodyReader(), params["boundary"]), nil
+}
+
+// IsMultipart reports whether the body is multipart/form-data, the server
+// leaves such bodies on the connection so handlers can stream them
+func (r *Request) IsMultipart() bool {
+	return r.mediaType() == "multipart/form-data"
+}
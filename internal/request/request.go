@@ -2,10 +2,15 @@ package request
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"tcpToHttp/internal/chunked"
+	"tcpToHttp/internal/cookie"
 	h "tcpToHttp/internal/headers"
+	"tcpToHttp/internal/message"
+	"tcpToHttp/internal/negotiate"
 )
 
 type parserState string
@@ -22,36 +27,81 @@ type RequestLine struct {
 	Method        string
 	RequestTarget string
 	HttpVersion   string
+	TargetForm    TargetForm
+	// Scheme and Authority are only set for absolute-form targets,
+	// Authority is also set for authority-form targets
+	Scheme    string
+	Authority string
+	// Path is the normalized path used for routing
+	Path     string
+	RawQuery string
 }
 
+// ValidHTTP accepts any HTTP/1.x version, a higher minor version than
+// 1.1 is handled with HTTP/1.1 semantics
 func (r *RequestLine) ValidHTTP() bool {
-	return r.HttpVersion == "1.1"
+	return strings.HasPrefix(r.HttpVersion, "1.")
+}
+
+func (r *RequestLine) IsHTTP10() bool {
+	return r.HttpVersion == "1.0"
 }
 
 type Request struct {
 	RequestLine RequestLine
 	Headers     *h.Headers
 	Body        []byte
-	state       parserState
+	// Params holds the path params of the matched route, a catch-all is
+	// also available as "*"
+	Params map[string]string
+	// RemoteAddr is the address of the client as set by the server
+	RemoteAddr string
+	state      parserState
+
+	// in holds the connection and the bytes read from it but not parsed
+	// yet, the body may be read after the head. A body without
+	// Content-Length ends with the reader, like the DATA frames of an
+	// HTTP/2 stream
+	in          *message.Reader
+	body        message.Body
+	onReadBody  func() error
+	maxBodySize int
+	// Trailers holds the trailer section of a chunked body once it was read
+	Trailers *h.Headers
+
+	// Form holds the query and urlencoded body values once ParseForm ran,
+	// PostForm only the body ones
+	Form     Values
+	PostForm Values
+	// MaxFormSize caps the urlencoded body read by ParseForm, 0 means
+	// DefaultMaxFormSize
+	MaxFormSize int
 }
 
 var ERROR_MALFORMED_REQ_LINE = fmt.Errorf("malformed request line.")
+var ERROR_MALFORMED_METHOD = fmt.Errorf("malformed method.")
 var ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("unsupported HTTP version.")
 var ERROR_REQUEST_IN_ERROR_STATE = fmt.Errorf("request in error state.")
 var ERROR_BODY_LENGTH_MISSMATCH = fmt.Errorf("body length missmatch.")
+var ERROR_UNSUPPORTED_TRANSFER_ENCODING = fmt.Errorf("unsupported transfer encoding.")
+var ERROR_AMBIGUOUS_LENGTH = fmt.Errorf("both transfer-encoding and content-length.")
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content-length.")
+var ERROR_BODY_TOO_LARGE = fmt.Errorf("body too large.")
+var ERROR_REQUEST_LINE_TOO_LONG = fmt.Errorf("request line too long.")
+var ERROR_HEAD_TOO_LARGE = fmt.Errorf("request head too large.")
 var CRLF = []byte("\r\n")
 
-func newRequest() *Request {
+func newRequest(reader io.Reader, buffered []byte) *Request {
 	return &Request{
 		state:   StateInit,
 		Headers: h.NewHeaders(),
 		Body:    []byte(""),
+		in:      message.NewReader(reader, buffered),
 	}
 }
 
 func (r *Request) hasBody() bool {
-	lenght := r.Headers.GetInt("content-length", 0)
-	return lenght > 0
+	return r.body.UntilEOF || r.body.Chunked != nil || r.body.Length > 0
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -93,41 +143,94 @@ outer:
 			read += n
 
 			if done {
+				if err := r.validateHost(); err != nil {
+					r.state = StateError
+					return 0, err
+				}
+				if err := r.framing(); err != nil {
+					r.state = StateError
+					return 0, err
+				}
 				if r.hasBody() {
 					r.state = StateBody
 				} else {
 					r.state = StateDone
 				}
+				// stop at the end of the head so the body can be read separately
+				break outer
 			}
 
 		case StateBody:
-			length := r.Headers.GetInt("content-length", 0)
-			if length == 0 {
-				panic("chunked not impl")
+			n, data, err := r.body.Next(currentData, len(currentData))
+			if err == nil {
+				err = r.addBody()
 			}
-
-			remaining := min(length-len(r.Body), len(currentData))
-			r.Body = append(r.Body, currentData[:remaining]...)
-			read += remaining
-
-			if len(r.Body) > length {
+			if err != nil {
 				r.state = StateError
-				return 0, ERROR_BODY_LENGTH_MISSMATCH
+				return 0, err
 			}
-			if len(r.Body) == length {
-				r.state = StateDone
+			r.Body = append(r.Body, data...)
+			read += n
+			if n == 0 {
+				break outer
 			}
 
 		case StateDone:
 			break outer
 
 		default:
-			panic("someting went wrong in parse method")
+			panic("something went wrong in parse method")
 		}
 	}
 	return read, nil
 }
 
+// framing works out how the body is delimited (RFC 9112 §6.3), any other
+// transfer coding than chunked or one next to Content-Length is rejected
+// since the length of the body couldn't be trusted
+func (r *Request) framing() error {
+	te, ok := r.Headers.Get("Transfer-Encoding")
+	if !ok {
+		if value, ok := r.Headers.Get("Content-Length"); ok {
+			length, valid := message.ParseContentLength(value)
+			if !valid {
+				return ERROR_INVALID_CONTENT_LENGTH
+			}
+			r.body.Length = length
+		}
+		return nil
+	}
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		return ERROR_AMBIGUOUS_LENGTH
+	}
+	if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+		return ERROR_UNSUPPORTED_TRANSFER_ENCODING
+	}
+	r.body.Chunked = chunked.NewDecoder()
+	return nil
+}
+
+// addBody checks the decoded body against the max body size and finishes
+// it once it is complete, a chunked one with its trailers
+func (r *Request) addBody() error {
+	if r.maxBodySize > 0 && r.body.Read > int64(r.maxBodySize) {
+		return ERROR_BODY_TOO_LARGE
+	}
+	if r.body.Done() {
+		if r.body.Chunked != nil {
+			r.Trailers = r.body.Chunked.Trailers
+		}
+		r.state = StateDone
+	}
+	return nil
+}
+
+// SetMaxBodySize caps a chunked body whose length isn't known up front,
+// reading more fails with ERROR_BODY_TOO_LARGE. 0 means no limit
+func (r *Request) SetMaxBodySize(size int) {
+	r.maxBodySize = size
+}
+
 func (r *Request) done() bool {
 	return r.state == StateDone || r.state == StateError
 }
@@ -141,47 +244,276 @@ func parseRequestLine(r []byte) (*RequestLine, int, error) {
 	startLine := r[:idx]
 	read := idx + len(CRLF)
 
-	parts := bytes.Split(startLine, []byte(" "))
+	// RFC 9112 §3 allows parsing on any whitespace instead of single spaces
+	parts := bytes.FieldsFunc(startLine, isWhitespace)
 	if len(parts) != 3 {
 		return nil, 0, ERROR_MALFORMED_REQ_LINE
 	}
 
+	if !h.IsToken(parts[0]) {
+		return nil, 0, ERROR_MALFORMED_METHOD
+	}
+
+	version, ok := parseHTTPVersion(parts[2])
+	if !ok {
+		return nil, 0, ERROR_MALFORMED_REQ_LINE
+	}
+
 	rl := &RequestLine{
 		Method:        string(parts[0]),
 		RequestTarget: string(parts[1]),
-		HttpVersion:   strings.TrimPrefix(string(parts[2]), "HTTP/"),
+		HttpVersion:   version,
 	}
 
 	if !rl.ValidHTTP() {
 		return nil, 0, ERROR_UNSUPPORTED_HTTP_VERSION
 	}
 
+	if err := rl.parseTarget(); err != nil {
+		return nil, 0, err
+	}
+
 	return rl, read, nil
 
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
+// parseHTTPVersion checks the HTTP-version grammar from RFC 9112 §2.3
+// and returns the "major.minor" part
+func parseHTTPVersion(b []byte) (string, bool) {
+	version, ok := bytes.CutPrefix(b, []byte("HTTP/"))
+	if !ok || len(version) != 3 || version[1] != '.' {
+		return "", false
+	}
+	if !isDigit(version[0]) || !isDigit(version[2]) {
+		return "", false
+	}
+	return string(version), true
+}
+
+func isWhitespace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
 
-	// TODO : buffer size could get larger
-	buf := make([]byte, 1024)
-	bufLen := 0
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
 
-	for !request.done() {
-		n, err := reader.Read(buf[bufLen:])
-		if err != nil {
-			return nil, err
+// KeepAlive reports whether the client wants the connection to stay open,
+// HTTP/1.1 connections are persistent unless closed explicitly while
+// HTTP/1.0 ones only persist when keep-alive is requested
+func (r *Request) KeepAlive() bool {
+	if r.Headers.HasToken("Connection", "close") {
+		return false
+	}
+	if r.RequestLine.IsHTTP10() {
+		return r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return true
+}
+
+// Cookies parses every Cookie header of the request
+func (r *Request) Cookies() []*cookie.Cookie {
+	cookies := []*cookie.Cookie{}
+	for _, value := range r.Headers.Values("Cookie") {
+		cookies = append(cookies, cookie.Parse(value)...)
+	}
+	return cookies
+}
+
+// Cookie returns the first cookie with the given name
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
 		}
+	}
+	return nil, false
+}
+
+// Negotiate picks the offered media type that best matches Accept
+func (r *Request) Negotiate(offers ...string) (string, bool) {
+	return negotiate.MediaType(r.Headers, offers...)
+}
+
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// ExpectsContinue reports whether the client waits for a 100 Continue
+// before sending the body, the expectation is ignored for HTTP/1.0
+func (r *Request) ExpectsContinue() bool {
+	if r.RequestLine.IsHTTP10() || !r.hasBody() {
+		return false
+	}
+	expect, _ := r.Headers.Get("Expect")
+	return strings.EqualFold(expect, "100-continue")
+}
+
+// OnReadBody registers a function that runs once right before the body is
+// read, the server uses it to send 100 Continue
+func (r *Request) OnReadBody(fn func() error) {
+	r.onReadBody = fn
+}
+
+// BodyRead reports whether the whole body has been read from the connection
+func (r *Request) BodyRead() bool {
+	return r.done()
+}
+
+func (r *Request) beforeBody() error {
+	if r.onReadBody == nil {
+		return nil
+	}
+	fn := r.onReadBody
+	r.onReadBody = nil
+	return fn()
+}
 
-		bufLen += n
-		readN, err := request.parse(buf[:bufLen])
-		if err != nil {
-			return nil, err
+// ReadBody reads the rest of the body into r.Body and returns it
+func (r *Request) ReadBody() ([]byte, error) {
+	if err := r.beforeBody(); err != nil {
+		return nil, err
+	}
+
+	if err := r.readUntil(r.done); err != nil {
+		return nil, err
+	}
+	return r.Body, nil
+}
+
+// BodyReader streams the rest of the body without keeping it in r.Body,
+// useful for uploads that shouldn't be buffered in memory
+func (r *Request) BodyReader() io.Reader {
+	return &bodyReader{r: r}
+}
+
+type bodyReader struct {
+	r *Request
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	if err := r.beforeBody(); err != nil {
+		return 0, err
+	}
+
+	switch r.state {
+	case StateDone:
+		return 0, io.EOF
+	case StateError:
+		return 0, ERROR_REQUEST_IN_ERROR_STATE
+	}
+
+	n, err := r.in.ReadBody(p, &r.body)
+	if err != nil && err != io.EOF {
+		r.state = StateError
+		return 0, err
+	}
+	if bodyErr := r.addBody(); bodyErr != nil {
+		r.state = StateError
+		return 0, bodyErr
+	}
+	return n, err
+}
+
+// Buffered returns the bytes read from the connection but not parsed yet,
+// like the start of a pipelined request or frames sent right after an
+// upgrade. Any unread body is part of them
+func (r *Request) Buffered() []byte {
+	return r.in.Buffered()
+}
+
+// readUntil parses the buffered bytes and reads more from the connection
+// until stop returns true
+func (r *Request) readUntil(stop func() bool) error {
+	err := r.in.ReadUntil(r.parse, r.inHead, stop)
+	switch {
+	case err == io.EOF && r.state == StateBody && r.body.UntilEOF:
+		r.body.End()
+		r.state = StateDone
+		return nil
+	case errors.Is(err, message.ERROR_HEAD_TOO_LARGE):
+		lineDone := r.state != StateInit
+		r.state = StateError
+		if !lineDone {
+			return ERROR_REQUEST_LINE_TOO_LONG
 		}
+		return ERROR_HEAD_TOO_LARGE
+	}
+	return err
+}
+
+func (r *Request) inHead() bool {
+	return r.state == StateInit || r.state == StateHeaders
+}
+
+// HeadFromReader reads the request line and headers, the body is left on
+// the connection until ReadBody is called
+func HeadFromReader(reader io.Reader) (*Request, error) {
+	return HeadFromBuffered(reader, nil)
+}
+
+// HeadFromBuffered is HeadFromReader for a connection where buffered was
+// already read, like the bytes of pipelined requests that followed the
+// previous one (see Buffered)
+func HeadFromBuffered(reader io.Reader, buffered []byte) (*Request, error) {
+	request := newRequest(reader, buffered)
+	headDone := func() bool {
+		return request.state != StateInit && request.state != StateHeaders
+	}
+	if err := request.readUntil(headDone); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
 
-		copy(buf, buf[readN:bufLen])
-		bufLen -= readN
+// NewRequest builds a request from an already parsed head, like the
+// pseudo-header fields of an HTTP/2 stream. The body is read from body
+// which is limited to Content-Length when set and else read until EOF, a
+// nil body means there is none
+func NewRequest(method, target, version string, headers *h.Headers, body io.Reader) (*Request, error) {
+	if !h.IsToken([]byte(method)) || method == "" {
+		return nil, ERROR_MALFORMED_METHOD
+	}
+	rl := RequestLine{
+		Method:        method,
+		RequestTarget: target,
+		HttpVersion:   version,
+	}
+	if err := rl.parseTarget(); err != nil {
+		return nil, err
 	}
 
+	request := newRequest(body, nil)
+	request.RequestLine = rl
+	request.Headers = headers
+	if err := request.validateHost(); err != nil {
+		return nil, err
+	}
+
+	_, hasLength := headers.Get("Content-Length")
+	request.body.Length = int64(headers.GetInt("Content-Length", 0))
+	request.body.UntilEOF = body != nil && !hasLength
+	if body != nil && request.hasBody() {
+		request.state = StateBody
+	} else {
+		request.state = StateDone
+	}
+	return request, nil
+}
+
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	request, err := HeadFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := request.ReadBody(); err != nil {
+		return nil, err
+	}
 	return request, nil
 }
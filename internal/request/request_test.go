@@ -2,39 +2,20 @@ package request
 
 import (
 	"io"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/testutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-type chunkReader struct {
-	data            string
-	numBytesPerRead int
-	pos             int
-}
-
-// Read reads up to len(p) or numBytesPerRead bytes from the string per call
-// its useful for simulating reading a variable number of bytes per chunk from a network connection
-func (cr *chunkReader) Read(p []byte) (n int, err error) {
-	if cr.pos >= len(cr.data) {
-		return 0, io.EOF
-	}
-	endIndex := cr.pos + cr.numBytesPerRead
-	if endIndex > len(cr.data) {
-		endIndex = len(cr.data)
-	}
-	n = copy(p, cr.data[cr.pos:endIndex])
-	cr.pos += n
-
-	return n, nil
-}
-
 // func TestRequestLineParse(t *testing.T) {
 // 	// Test: Good GET Request line
-// 	reader := &chunkReader{
-// 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
-// 		numBytesPerRead: 3,
+// 	reader := &testutil.ChunkReader{
+// 		Data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+// 		NumBytesPerRead: 3,
 // 	}
 // 	r, err := RequestFromReader(reader)
 // 	require.NoError(t, err)
@@ -44,9 +25,9 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 // 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
 
 // 	// Test: Good GET Request line with path
-// 	reader = &chunkReader{
-// 		data:            "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
-// 		numBytesPerRead: 1,
+// 	reader = &testutil.ChunkReader{
+// 		Data:            "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+// 		NumBytesPerRead: 1,
 // 	}
 // 	r, err = RequestFromReader(reader)
 // 	require.NoError(t, err)
@@ -56,9 +37,9 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 // 	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
 
 // 	// Test: Invalid number of parts in request line
-// 	reader = &chunkReader{
-// 		data:            "/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
-// 		numBytesPerRead: 1,
+// 	reader = &testutil.ChunkReader{
+// 		Data:            "/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+// 		NumBytesPerRead: 1,
 // 	}
 
 // 	_, err = RequestFromReader(reader)
@@ -68,9 +49,9 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 // func TestRequestHeadersParse(t *testing.T) {
 // 	// Test: Standard Headers
-// 	reader := &chunkReader{
-// 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
-// 		numBytesPerRead: 3,
+// 	reader := &testutil.ChunkReader{
+// 		Data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+// 		NumBytesPerRead: 3,
 // 	}
 // 	r, err := RequestFromReader(reader)
 // 	require.NoError(t, err)
@@ -89,9 +70,9 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 // 	assert.Equal(t, "*/*", accept)
 
 // 	// Test: Malformed Header
-// 	reader = &chunkReader{
-// 		data:            "GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
-// 		numBytesPerRead: 3,
+// 	reader = &testutil.ChunkReader{
+// 		Data:            "GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
+// 		NumBytesPerRead: 3,
 // 	}
 // 	r, err = RequestFromReader(reader)
 // 	require.Error(t, err)
@@ -99,13 +80,13 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 func TestRequestBodyParse(t *testing.T) {
 	// Test: Standard Body
-	reader := &chunkReader{
-		data: "POST /submit HTTP/1.1\r\n" +
+	reader := &testutil.ChunkReader{
+		Data: "POST /submit HTTP/1.1\r\n" +
 			"Host: localhost:42069\r\n" +
 			"Content-Length: 13\r\n" +
 			"\r\n" +
 			"hello world!\n",
-		numBytesPerRead: 3,
+		NumBytesPerRead: 3,
 	}
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
@@ -113,14 +94,538 @@ func TestRequestBodyParse(t *testing.T) {
 	assert.Equal(t, "hello world!\n", string(r.Body))
 
 	// Test: Body shorter than reported content length
-	reader = &chunkReader{
-		data: "POST /submit HTTP/1.1\r\n" +
+	reader = &testutil.ChunkReader{
+		Data: "POST /submit HTTP/1.1\r\n" +
 			"Host: localhost:42069\r\n" +
 			"Content-Length: 20\r\n" +
 			"\r\n" +
 			"partial content",
-		numBytesPerRead: 3,
+		NumBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestRequestChunkedBody(t *testing.T) {
+	data := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"6\r\nhello \r\n" +
+		"6;ext=1\r\nworld!\r\n" +
+		"0\r\n" +
+		"X-Checksum: abc\r\n" +
+		"\r\n" +
+		"GET / HTTP/1.1\r\n"
+
+	// Test: Chunked body with trailers, the next request stays buffered
+	for _, perRead := range []int{1, 3, 100} {
+		r, err := RequestFromReader(&testutil.ChunkReader{Data: data, NumBytesPerRead: perRead})
+		require.NoError(t, err)
+		assert.Equal(t, "hello world!", string(r.Body))
+		checksum, _ := r.Trailers.Get("X-Checksum")
+		assert.Equal(t, "abc", checksum)
+		assert.True(t, strings.HasPrefix("GET / HTTP/1.1\r\n", string(r.Buffered())))
+	}
+
+	// Test: Streaming a chunked body
+	r, err := HeadFromReader(&testutil.ChunkReader{Data: data, NumBytesPerRead: 5})
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.True(t, r.BodyRead())
+
+	// Test: Body larger than the limit
+	r, err = HeadFromReader(&testutil.ChunkReader{Data: data, NumBytesPerRead: 5})
+	require.NoError(t, err)
+	r.SetMaxBodySize(8)
+	_, err = r.ReadBody()
+	assert.Equal(t, ERROR_BODY_TOO_LARGE, err)
+
+	// Test: Transfer-Encoding next to Content-Length
+	_, err = RequestFromReader(&testutil.ChunkReader{
+		Data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n",
+		NumBytesPerRead: 8,
+	})
+	assert.Equal(t, ERROR_AMBIGUOUS_LENGTH, err)
+
+	// Test: Content-Length that isn't a plain number or whose values differ
+	for _, value := range []string{"abc", "-5", "+5", "5, 6", "0x5", ""} {
+		_, err = RequestFromReader(&testutil.ChunkReader{
+			Data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: " + value + "\r\n\r\nhello",
+			NumBytesPerRead: 8,
+		})
+		assert.Equal(t, ERROR_INVALID_CONTENT_LENGTH, err, value)
+	}
+
+	// Test: Repeated Content-Length with the same value
+	r, err = RequestFromReader(&testutil.ChunkReader{
+		Data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 5\r\nContent-Length: 5\r\n\r\nhello",
+		NumBytesPerRead: 8,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Unknown transfer coding
+	_, err = RequestFromReader(&testutil.ChunkReader{
+		Data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+		NumBytesPerRead: 8,
+	})
+	assert.Equal(t, ERROR_UNSUPPORTED_TRANSFER_ENCODING, err)
+
+	// Test: Malformed chunk
+	_, err = RequestFromReader(&testutil.ChunkReader{
+		Data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n",
+		NumBytesPerRead: 8,
+	})
+	assert.Error(t, err)
+}
+
+func TestRequestTargetForms(t *testing.T) {
+	// Test: origin-form with query and dot segments
+	reader := &testutil.ChunkReader{
+		Data:            "GET /a/./b/../c?x=1 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, FormOrigin, r.RequestLine.TargetForm)
+	assert.Equal(t, "/a/c", r.RequestLine.Path)
+	assert.Equal(t, "x=1", r.RequestLine.RawQuery)
+	assert.Equal(t, "http://localhost:42069/a/c?x=1", r.EffectiveURI())
+
+	// Test: absolute-form
+	reader = &testutil.ChunkReader{
+		Data:            "GET HTTP://example.com:8080 HTTP/1.1\r\nHost: example.com:8080\r\n\r\n",
+		NumBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, FormAbsolute, r.RequestLine.TargetForm)
+	assert.Equal(t, "http", r.RequestLine.Scheme)
+	assert.Equal(t, "example.com:8080", r.RequestLine.Authority)
+	assert.Equal(t, "/", r.RequestLine.Path)
+	assert.Equal(t, "http://example.com:8080/", r.EffectiveURI())
+
+	// Test: authority-form
+	reader = &testutil.ChunkReader{
+		Data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		NumBytesPerRead: 2,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, FormAuthority, r.RequestLine.TargetForm)
+	assert.Equal(t, "example.com:443", r.RequestLine.Authority)
+	assert.Equal(t, "http://example.com:443", r.EffectiveURI())
+
+	// Test: asterisk-form
+	reader = &testutil.ChunkReader{
+		Data:            "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, FormAsterisk, r.RequestLine.TargetForm)
+	assert.Equal(t, "*", r.RequestLine.Path)
+	assert.Equal(t, "http://localhost", r.EffectiveURI())
+
+	// Test: asterisk-form with a method other than OPTIONS
+	reader = &testutil.ChunkReader{
+		Data:            "GET * HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_REQ_TARGET)
+
+	// Test: authority-form without a port
+	reader = &testutil.ChunkReader{
+		Data:            "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		NumBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_REQ_TARGET)
+
+	// Test: CONNECT only takes authority-form
+	for _, target := range []string{"/path", "http://example.com:443/", "*"} {
+		reader = &testutil.ChunkReader{
+			Data:            "CONNECT " + target + " HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+			NumBytesPerRead: 4,
+		}
+		_, err = RequestFromReader(reader)
+		require.ErrorIs(t, err, ERROR_MALFORMED_REQ_TARGET, target)
+	}
+
+	// Test: absolute-form with userinfo
+	reader = &testutil.ChunkReader{
+		Data:            "GET http://user@example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		NumBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_REQ_TARGET)
+}
+
+func TestRequestHostValidation(t *testing.T) {
+	// Test: Missing Host
+	reader := &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nAccept: */*\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err := RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MISSING_HOST)
+
+	// Test: Multiple Host field lines
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: a.local\r\nHost: b.local\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MULTIPLE_HOST)
+
+	// Test: Invalid Host value
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: a.local:80x\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_INVALID_HOST)
+
+	// Test: Host is lowercased and stripped of the port
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: API.Example.Local:42069\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.local", r.Host())
+}
+
+func TestRequestHTTPVersion(t *testing.T) {
+	// Test: HTTP/1.0 without Host
+	reader := &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.0\r\nUser-Agent: curl/7.81.0\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.True(t, r.RequestLine.IsHTTP10())
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 asking for keep-alive
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 closing the connection
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Unsupported major version
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_UNSUPPORTED_HTTP_VERSION)
+
+	// Test: Malformed version
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_REQ_LINE)
+}
+
+func TestRequestHeadSize(t *testing.T) {
+	// Test: A request line that never ends
+	reader := &testutil.ChunkReader{
+		Data:            "GET /" + strings.Repeat("a", 100_000) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 1024,
+	}
+	_, err := RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: Headers over the limit
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat("X-Filler: aaaaaaaaaaaaaaaaaaaa\r\n", 3000) + "\r\n",
+		NumBytesPerRead: 1024,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_HEAD_TOO_LARGE)
+
+	// Test: A single header line over the limit
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 100_000) + "\r\n\r\n",
+		NumBytesPerRead: 1024,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_HEAD_TOO_LARGE)
+
+	// Test: A large head under the limit
+	reader = &testutil.ChunkReader{
+		Data:            "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 60_000) + "\r\n\r\n",
+		NumBytesPerRead: 1024,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	value, _ := r.Headers.Get("X-Big")
+	assert.Len(t, value, 60_000)
+}
+
+func TestRequestMethodParse(t *testing.T) {
+	// Test: Extension method with whitespace runs between parts
+	reader := &testutil.ChunkReader{
+		Data:            "PROPFIND  /files\tHTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "PROPFIND", r.RequestLine.Method)
+	assert.Equal(t, "/files", r.RequestLine.Path)
+
+	// Test: Lowercase methods are kept as is
+	reader = &testutil.ChunkReader{
+		Data:            "get / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "get", r.RequestLine.Method)
+
+	// Test: Method that is not a token
+	reader = &testutil.ChunkReader{
+		Data:            "G(E)T / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_METHOD)
+
+	// Test: Binary garbage as method
+	reader = &testutil.ChunkReader{
+		Data:            "\x00\x01\x02 / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ERROR_MALFORMED_METHOD)
+}
+
+func TestRequestExpectContinue(t *testing.T) {
+	// Test: Body is only read after the head when asked for
+	reader := &testutil.ChunkReader{
+		Data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"Expect: 100-Continue\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		NumBytesPerRead: 3,
+	}
+	r, err := HeadFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	assert.False(t, r.BodyRead())
+	assert.Equal(t, "", string(r.Body))
+
+	called := 0
+	r.OnReadBody(func() error {
+		called++
+		return nil
+	})
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.True(t, r.BodyRead())
+	assert.Equal(t, 1, called)
+
+	// Test: Expectation is ignored for HTTP/1.0
+	reader = &testutil.ChunkReader{
+		Data: "POST /upload HTTP/1.0\r\n" +
+			"Content-Length: 2\r\n" +
+			"Expect: 100-continue\r\n" +
+			"\r\n" +
+			"hi",
+		NumBytesPerRead: 3,
+	}
+	r, err = HeadFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Head larger than the initial buffer
+	reader = &testutil.ChunkReader{
+		Data: "GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"X-Large: " + strings.Repeat("a", 4096) + "\r\n" +
+			"\r\n",
+		NumBytesPerRead: 512,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	large, _ := r.Headers.Get("X-Large")
+	assert.Len(t, large, 4096)
+}
+
+func TestPathUnescape(t *testing.T) {
+	s, err := PathUnescape("/a%20file+name%2Fx")
+	require.NoError(t, err)
+	assert.Equal(t, "/a file+name/x", s)
+
+	_, err = PathUnescape("/bad%2")
+	require.ErrorIs(t, err, ERROR_INVALID_ESCAPE)
+	_, err = PathUnescape("/bad%zz")
+	require.ErrorIs(t, err, ERROR_INVALID_ESCAPE)
+}
+
+func TestRequestCookies(t *testing.T) {
+	// Test: Cookies from the Cookie header
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: session=abc; theme=dark\r\n\r\n"))
+	require.NoError(t, err)
+	require.Len(t, r.Cookies(), 2)
+	c, ok := r.Cookie("theme")
+	require.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+func TestRequestForm(t *testing.T) {
+	// Test: Urlencoded body merged with the query
+	body := "name=Jane+Doe&lang=go&lang=c%2B%2B"
+	reader := &testutil.ChunkReader{
+		Data:            "POST /submit?lang=rust&page=2 HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/x-www-form-urlencoded; charset=utf-8\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body,
+		NumBytesPerRead: 3,
+	}
+	r, err := HeadFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "Jane Doe", r.FormValue("name"))
+	assert.Equal(t, []string{"go", "c++", "rust"}, r.Form["lang"])
+	assert.Equal(t, []string{"go", "c++"}, r.PostForm["lang"])
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.True(t, r.BodyRead())
+
+	// Test: Query only for other content types
+	r, err = RequestFromReader(strings.NewReader("POST /submit?a=1 HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\na=2"))
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"1"}, r.Form["a"])
+	assert.Empty(t, r.PostForm)
+
+	// Test: Body larger than MaxFormSize
+	r, err = HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 20\r\n\r\na=aaaaaaaaaaaaaaaaaa"))
+	require.NoError(t, err)
+	r.MaxFormSize = 10
+	assert.Equal(t, ERROR_FORM_TOO_LARGE, r.ParseForm())
+
+	// Test: Chunked body larger than MaxFormSize
+	r, err = HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/x-www-form-urlencoded\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"8\r\na=aaaaaa\r\n8\r\naaaaaaaa\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	r.MaxFormSize = 10
+	assert.Equal(t, ERROR_FORM_TOO_LARGE, r.ParseForm())
+
+	// Test: A body read before under a larger limit
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/x-www-form-urlencoded\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"8\r\na=aaaaaa\r\n8\r\naaaaaaaa\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	r.MaxFormSize = 10
+	assert.Equal(t, ERROR_FORM_TOO_LARGE, r.ParseForm())
+
+	// Test: Invalid escapes
+	values, err := ParseQuery("a=%zz&b=2")
+	assert.Equal(t, ERROR_INVALID_ESCAPE, err)
+	assert.Equal(t, Values{"b": {"2"}}, values)
+}
+
+func TestRequestMultipart(t *testing.T) {
+	body := "--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"hello\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"notes.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"line one\r\nline two\r\n" +
+		"--xyz--\r\n"
+
+	// Test: Parts are streamed from the connection
+	reader := &testutil.ChunkReader{
+		Data:            "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=xyz\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body,
+		NumBytesPerRead: 4,
+	}
+	r, err := HeadFromReader(reader)
+	require.NoError(t, err)
+	require.True(t, r.IsMultipart())
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "file", part.FormName())
+	assert.Equal(t, "notes.txt", part.FileName())
+	data, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two", string(data))
+
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, r.BodyRead())
+	assert.Empty(t, r.Body)
+
+	// Test: Not a multipart body
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.MultipartReader()
+	assert.Equal(t, ERROR_NOT_MULTIPART, err)
+}
+
+func TestRequestPipelined(t *testing.T) {
+	// Test: Bytes after the first request are kept for the next one
+	reader := &testutil.ChunkReader{
+		Data: "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /c HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		NumBytesPerRead: 40,
+	}
+	paths := []string{}
+	var buffered []byte
+	for {
+		r, err := HeadFromBuffered(reader, buffered)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		_, err = r.ReadBody()
+		require.NoError(t, err)
+		paths = append(paths, r.RequestLine.Path+":"+string(r.Body))
+		buffered = r.Buffered()
+	}
+	assert.Equal(t, []string{"/a:hello", "/b:", "/c:"}, paths)
+
+	// Test: A whole request already buffered needs no read
+	r, err := HeadFromBuffered(strings.NewReader(""), []byte("GET /d HTTP/1.1\r\nHost: localhost\r\n\r\nGET"))
+	require.NoError(t, err)
+	assert.Equal(t, "/d", r.RequestLine.Path)
+	assert.Equal(t, "GET", string(r.Buffered()))
+}
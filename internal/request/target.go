@@ -0,0 +1,222 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TargetForm string
+
+// request-target forms from RFC 9112 §3.2
+const (
+	FormOrigin    TargetForm = "origin"
+	FormAbsolute  TargetForm = "absolute"
+	FormAuthority TargetForm = "authority"
+	FormAsterisk  TargetForm = "asterisk"
+)
+
+var ERROR_MALFORMED_REQ_TARGET = fmt.Errorf("malformed request target.")
+var ERROR_MISSING_HOST = fmt.Errorf("missing host header.")
+var ERROR_MULTIPLE_HOST = fmt.Errorf("multiple host headers.")
+var ERROR_INVALID_HOST = fmt.Errorf("invalid host header.")
+
+// parseTarget fills the target related fields of the request line
+// based on the raw request target and the method
+func (rl *RequestLine) parseTarget() error {
+	target := rl.RequestTarget
+	if target == "" {
+		return ERROR_MALFORMED_REQ_TARGET
+	}
+
+	switch {
+	case rl.Method == "CONNECT":
+		// authority-form is the only form allowed for CONNECT and only
+		// used by it (RFC 9112 §3.2.3)
+		if !validAuthority(target, true) {
+			return ERROR_MALFORMED_REQ_TARGET
+		}
+		rl.TargetForm = FormAuthority
+		rl.Authority = target
+
+	case target == "*":
+		// asterisk-form is only used for a server-wide OPTIONS request
+		if rl.Method != "OPTIONS" {
+			return ERROR_MALFORMED_REQ_TARGET
+		}
+		rl.TargetForm = FormAsterisk
+		rl.Path = "*"
+
+	case strings.HasPrefix(target, "/"):
+		rl.TargetForm = FormOrigin
+		path, query, _ := strings.Cut(target, "?")
+		rl.Path = normalizePath(path)
+		rl.RawQuery = query
+
+	default:
+		scheme, rest, ok := strings.Cut(target, "://")
+		if !ok || !validScheme(scheme) {
+			return ERROR_MALFORMED_REQ_TARGET
+		}
+
+		end := strings.IndexAny(rest, "/?")
+		if end == -1 {
+			end = len(rest)
+		}
+		authority := rest[:end]
+		if !validAuthority(authority, false) {
+			return ERROR_MALFORMED_REQ_TARGET
+		}
+
+		path, query, _ := strings.Cut(rest[end:], "?")
+		rl.TargetForm = FormAbsolute
+		rl.Scheme = strings.ToLower(scheme)
+		rl.Authority = authority
+		rl.Path = normalizePath(path)
+		rl.RawQuery = query
+	}
+	return nil
+}
+
+func validScheme(scheme string) bool {
+	switch strings.ToLower(scheme) {
+	case "http", "https":
+		return true
+	}
+	return false
+}
+
+// validAuthority checks a host[:port] authority, userinfo is not allowed
+// since it was deprecated for http(s) URIs in RFC 9110 §4.2.4
+func validAuthority(authority string, portRequired bool) bool {
+	if authority == "" || strings.ContainsAny(authority, "@/?# \t") {
+		return false
+	}
+
+	host, port := splitHostPort(authority)
+	if host == "" {
+		return false
+	}
+	if portRequired && port == "" {
+		return false
+	}
+	for _, ch := range port {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validateHost enforces RFC 9112 §3.2, an HTTP/1.1 request must carry
+// exactly one Host field line with a valid value
+func (r *Request) validateHost() error {
+	hosts := r.Headers.Values("Host")
+	switch {
+	case len(hosts) == 0 && r.RequestLine.IsHTTP10():
+		// Host is optional for HTTP/1.0
+		return nil
+	case len(hosts) == 0:
+		return ERROR_MISSING_HOST
+	case len(hosts) > 1:
+		return ERROR_MULTIPLE_HOST
+	}
+
+	// an empty Host is allowed when the target has no authority
+	if hosts[0] != "" && !validAuthority(hosts[0], false) {
+		return ERROR_INVALID_HOST
+	}
+	return nil
+}
+
+// Host returns the host the request is addressed to without the port,
+// the authority of an absolute-form target takes precedence over Host
+func (r *Request) Host() string {
+	authority := r.RequestLine.Authority
+	if authority == "" {
+		authority, _ = r.Headers.Get("Host")
+	}
+
+	host, _ := splitHostPort(authority)
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host
+}
+
+// splitHostPort splits an authority into host and port, it handles
+// IP-literals like [::1]:8080
+func splitHostPort(authority string) (string, string) {
+	if strings.HasPrefix(authority, "[") {
+		end := strings.Index(authority, "]")
+		if end == -1 {
+			return "", ""
+		}
+		host := authority[:end+1]
+		rest := authority[end+1:]
+		if rest == "" {
+			return host, ""
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", ""
+		}
+		return host, rest[1:]
+	}
+
+	host, port, _ := strings.Cut(authority, ":")
+	return host, port
+}
+
+// normalizePath removes dot segments (RFC 3986 §5.2.4) so routing
+// never sees ".." or "." and an empty path becomes "/"
+func normalizePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	out := make([]string, 0, len(segments))
+	for _, seg := range segments[1:] {
+		switch seg {
+		case ".":
+			continue
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+
+	// keep the trailing slash for paths like "/a/." and "/a/.."
+	last := segments[len(segments)-1]
+	if (last == "." || last == "..") && (len(out) == 0 || out[len(out)-1] != "") {
+		out = append(out, "")
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// EffectiveURI reconstructs the target URI of the request as
+// described in RFC 9112 §3.3
+func (r *Request) EffectiveURI() string {
+	rl := r.RequestLine
+	if rl.TargetForm == FormAbsolute {
+		uri := rl.Scheme + "://" + rl.Authority + rl.Path
+		if rl.RawQuery != "" {
+			uri += "?" + rl.RawQuery
+		}
+		return uri
+	}
+
+	authority := rl.Authority
+	if rl.TargetForm != FormAuthority {
+		authority, _ = r.Headers.Get("Host")
+	}
+
+	uri := "http://" + authority
+	if rl.TargetForm == FormOrigin {
+		uri += rl.Path
+		if rl.RawQuery != "" {
+			uri += "?" + rl.RawQuery
+		}
+	}
+	return uri
+}
@@ -0,0 +1,153 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/request"
+	"time"
+)
+
+// StrongETag returns an entity tag derived from a hash of the content
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
+}
+
+// WeakETag returns a weak entity tag for content that is only
+// semantically equivalent between versions
+func WeakETag(content []byte) string {
+	return "W/" + StrongETag(content)
+}
+
+// parseETags splits an If-Match or If-None-Match list, a comma can appear
+// inside an entity tag so the quotes are followed
+func parseETags(value string) []string {
+	tags := []string{}
+	for value != "" {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+			continue
+		}
+
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			// not an entity tag, skip to the next member
+			_, value, _ = strings.Cut(value, ",")
+			continue
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end == -1 {
+			break
+		}
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+	return tags
+}
+
+func opaqueTag(tag string) string {
+	return strings.TrimPrefix(tag, "W/")
+}
+
+// etagMatches compares etag with a list header, strong comparison fails
+// whenever either tag is weak (RFC 9110 §8.8.3.2). "*" matches any current
+// representation, with or without an etag
+func etagMatches(list, etag string, strong bool) bool {
+	for _, tag := range parseETags(list) {
+		if tag == "*" {
+			return true
+		}
+		if etag == "" {
+			continue
+		}
+		if strong {
+			if !strings.HasPrefix(tag, "W/") && !strings.HasPrefix(etag, "W/") && tag == etag {
+				return true
+			}
+		} else if opaqueTag(tag) == opaqueTag(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since in the order of RFC 9110 §13.2.2
+// against the current etag and modtime (either may be empty) of a resource
+// that exists. When a precondition decides the response it writes a 304 or
+// 412 and returns true
+func CheckPreconditions(w *Writer, req *request.Request, etag string, modtime time.Time) (bool, error) {
+	method := req.RequestLine.Method
+	isGetOrHead := method == "GET" || method == "HEAD"
+	// HTTP dates only have second precision
+	modtime = modtime.Truncate(time.Second)
+
+	if ifMatch, ok := req.Headers.Get("If-Match"); ok {
+		if !etagMatches(ifMatch, etag, true) {
+			return true, writePrecondition(w, StatusPreconditionFailed, etag, modtime)
+		}
+	} else if since, ok := req.Headers.Get("If-Unmodified-Since"); ok && !modtime.IsZero() {
+		if date, ok := ParseHTTPDate(since); ok && modtime.After(date) {
+			return true, writePrecondition(w, StatusPreconditionFailed, etag, modtime)
+		}
+	}
+
+	if ifNoneMatch, ok := req.Headers.Get("If-None-Match"); ok {
+		if etagMatches(ifNoneMatch, etag, false) {
+			if isGetOrHead {
+				return true, writePrecondition(w, StatusNotModified, etag, modtime)
+			}
+			return true, writePrecondition(w, StatusPreconditionFailed, etag, modtime)
+		}
+	} else if since, ok := req.Headers.Get("If-Modified-Since"); ok && isGetOrHead && !modtime.IsZero() {
+		if date, ok := ParseHTTPDate(since); ok && !modtime.After(date) {
+			return true, writePrecondition(w, StatusNotModified, etag, modtime)
+		}
+	}
+	return false, nil
+}
+
+// writePrecondition writes a 304 or 412, a 304 keeps the validators and
+// caching headers the 200 would have had (RFC 9110 §15.4.5)
+func writePrecondition(w *Writer, statusCode StatusCode, etag string, modtime time.Time) error {
+	h := w.Header().Clone()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding"} {
+		h.Delete(name)
+	}
+	if etag != "" {
+		h.Set("ETag", etag, true)
+	}
+	if !modtime.IsZero() {
+		h.Set("Last-Modified", modtime.UTC().Format(TimeFormat), true)
+	}
+
+	if statusCode == StatusPreconditionFailed {
+		msg := []byte("precondition failed\n")
+		h.Set("Content-Type", "text/plain", true)
+		h.Set("Content-Length", strconv.Itoa(len(msg)), true)
+		if err := w.WriteStatusLine(statusCode); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(*h); err != nil {
+			return err
+		}
+		_, err := w.WriteBody(msg)
+		return err
+	}
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	return w.WriteHeaders(*h)
+}
@@ -0,0 +1,280 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/request"
+	"time"
+)
+
+var ERROR_INVALID_RANGE = fmt.Errorf("invalid range.")
+var ERROR_UNSATISFIABLE_RANGE = fmt.Errorf("unsatisfiable range.")
+
+// maxRanges caps the number of ranges in one request, more than that is
+// treated as a request for the whole content
+const maxRanges = 64
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header (RFC 9110 §14.2) against a
+// representation of size bytes
+func parseRange(value string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(value, "bytes=")
+	if !ok {
+		return nil, ERROR_INVALID_RANGE
+	}
+
+	ranges := []byteRange{}
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ERROR_INVALID_RANGE
+		}
+
+		if first == "" {
+			// suffix range, the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, ERROR_INVALID_RANGE
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{size - n, n})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, ERROR_INVALID_RANGE
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, ERROR_INVALID_RANGE
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start, end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, ERROR_UNSATISFIABLE_RANGE
+	}
+	return ranges, nil
+}
+
+// ServeContent writes content as the response to req, it answers Range
+// requests with 206 Partial Content (multipart/byteranges for several
+// ranges) or 416 and honours If-Range. Conditional requests are answered
+// with 304 or 412 using modtime and an ETag set on w.Header(). Unless set
+// there the content type is guessed from the extension of name and then
+// from the first bytes of content
+func ServeContent(w *Writer, req *request.Request, name string, modtime time.Time, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// headers set by the caller like ETag or Cache-Control are kept
+	h := w.Header().Clone()
+
+	etag, _ := h.Get("ETag")
+	if done, err := CheckPreconditions(w, req, etag, modtime); done || err != nil {
+		return err
+	}
+
+	contentType, _ := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = TypeByExtension(name)
+	}
+	if contentType == "" {
+		contentType, err = sniff(content)
+		if err != nil {
+			return err
+		}
+	}
+	h.Set("Content-Type", contentType, true)
+	h.Set("Accept-Ranges", "bytes", true)
+	if !modtime.IsZero() {
+		h.Set("Last-Modified", modtime.UTC().Format(TimeFormat), true)
+	}
+
+	method := req.RequestLine.Method
+	rangeValue, hasRange := req.Headers.Get("Range")
+	if !hasRange || (method != "GET" && method != "HEAD") || !ifRangeMatches(req, h) {
+		return serveFull(w, req, h, content, size)
+	}
+
+	ranges, err := parseRange(rangeValue, size)
+	if errors.Is(err, ERROR_UNSATISFIABLE_RANGE) {
+		msg := []byte("range not satisfiable\n")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size), true)
+		h.Set("Content-Type", "text/plain", true)
+		h.Set("Content-Length", strconv.Itoa(len(msg)), true)
+		if err := w.WriteStatusLine(StatusRangeNotSatisfiable); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(*h); err != nil {
+			return err
+		}
+		_, err = w.WriteBody(msg)
+		return err
+	}
+	if err != nil || len(ranges) > maxRanges || sumRanges(ranges) > size {
+		// a broken or abusive Range is ignored and the whole content is sent
+		return serveFull(w, req, h, content, size)
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		h.Set("Content-Range", r.contentRange(size), true)
+		h.Set("Content-Length", strconv.FormatInt(r.length, 10), true)
+		if err := w.WriteStatusLine(StatusPartialContent); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(*h); err != nil {
+			return err
+		}
+		if method == "HEAD" {
+			return nil
+		}
+		return copyRange(w, content, r)
+	}
+	return serveMultipart(w, req, h, content, size, ranges)
+}
+
+// ifRangeMatches reports whether a Range should be honoured, an If-Range
+// with a date must match Last-Modified exactly. Entity tags are compared
+// against the ETag when the caller set one
+func ifRangeMatches(req *request.Request, h *headers.Headers) bool {
+	ifRange, ok := req.Headers.Get("If-Range")
+	if !ok {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		etag, ok := h.Get("ETag")
+		// weak tags never match in If-Range
+		return ok && !strings.HasPrefix(ifRange, "W/") && ifRange == etag
+	}
+	lastModified, ok := h.Get("Last-Modified")
+	return ok && ifRange == lastModified
+}
+
+func sniff(content io.ReadSeeker) (string, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return DetectContentType(buf[:n]), nil
+}
+
+func sumRanges(ranges []byteRange) int64 {
+	sum := int64(0)
+	for _, r := range ranges {
+		sum += r.length
+	}
+	return sum
+}
+
+func serveFull(w *Writer, req *request.Request, h *headers.Headers, content io.ReadSeeker, size int64) error {
+	h.Set("Content-Length", strconv.FormatInt(size, 10), true)
+	if err := w.WriteStatusLine(StatusOK); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+	return copyRange(w, content, byteRange{0, size})
+}
+
+func serveMultipart(w *Writer, req *request.Request, h *headers.Headers, content io.ReadSeeker, size int64, ranges []byteRange) error {
+	boundary, err := newBoundary()
+	if err != nil {
+		return err
+	}
+	contentType, _ := h.Get("Content-Type")
+
+	// every part head is known up front so the total length is too
+	partHeads := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		partHeads[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.contentRange(size))
+		length += int64(len(partHeads[i])) + r.length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	length += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary, true)
+	h.Set("Content-Length", strconv.FormatInt(length, 10), true)
+	if err := w.WriteStatusLine(StatusPartialContent); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(partHeads[i])); err != nil {
+			return err
+		}
+		if err := copyRange(w, content, r); err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteBody([]byte(closing))
+	return err
+}
+
+func copyRange(w *Writer, content io.ReadSeeker, r byteRange) error {
+	if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, content, r.length)
+	return err
+}
+
+func newBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,65 @@
+package response
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format used by Date and other HTTP dates
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Clock returns the current time, tests inject a fixed one
+type Clock func() time.Time
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+// DateCache formats the Date header at most once per second, every other
+// response in the same second reuses the cached value
+type DateCache struct {
+	clock  Clock
+	cached atomic.Pointer[cachedDate]
+}
+
+func NewDateCache(clock Clock) *DateCache {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &DateCache{
+		clock: clock,
+	}
+}
+
+func (d *DateCache) Date() string {
+	now := d.clock()
+	unix := now.Unix()
+	if c := d.cached.Load(); c != nil && c.unix == unix {
+		return c.value
+	}
+
+	value := now.UTC().Format(TimeFormat)
+	d.cached.Store(&cachedDate{
+		unix:  unix,
+		value: value,
+	})
+	return value
+}
+
+// old date formats recipients must still accept (RFC 9110 §5.6.7)
+var dateFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseHTTPDate parses an HTTP date in any of the formats from RFC 9110
+func ParseHTTPDate(value string) (time.Time, bool) {
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
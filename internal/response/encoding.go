@@ -0,0 +1,72 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"tcpToHttp/internal/headers"
+)
+
+// Encoding is a content coding negotiated for one response, usually set
+// by a compression middleware
+type Encoding struct {
+	// Name is the Content-Encoding token, empty when the client only
+	// accepts identity and the response just needs a Vary header
+	Name string
+	New  func(w io.Writer) io.WriteCloser
+	// MinSize is the smallest body worth encoding, bodies of unknown
+	// length are always encoded
+	MinSize int
+	// Skip reports content types that are already compressed
+	Skip func(contentType string) bool
+}
+
+func (w *Writer) SetEncoding(encoding *Encoding) {
+	w.encoding = encoding
+}
+
+// useEncoding adds Vary for responses that depend on Accept-Encoding and
+// reports whether the body described by h should be encoded, size is -1
+// when the length is unknown
+func (w *Writer) useEncoding(status StatusCode, h *headers.Headers, size int) bool {
+	e := w.encoding
+	if e == nil || !status.AllowsBody() || status == StatusPartialContent {
+		return false
+	}
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	if e.Skip != nil && e.Skip(strings.ToLower(contentType)) {
+		return false
+	}
+
+	if !h.HasToken("Vary", "Accept-Encoding") {
+		h.Set("Vary", "Accept-Encoding", false)
+	}
+	if e.Name == "" || e.New == nil {
+		return false
+	}
+	return size < 0 || size >= e.MinSize
+}
+
+// setEncoded marks h as describing the encoded body, a strong ETag of the
+// identity body is weakened since the bytes sent differ (RFC 9110 §8.8.1)
+func (w *Writer) setEncoded(h *headers.Headers) {
+	h.Set("Content-Encoding", w.encoding.Name, true)
+	if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag, true)
+	}
+}
+
+func (w *Writer) encodeAll(p []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := w.encoding.New(buf)
+	if _, err := enc.Write(p); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+var ERROR_INVALID_REDIRECT = fmt.Errorf("redirect needs a 3xx status code.")
+
+// the helpers below write a whole response in buffered mode, Content-Length
+// is filled in when the handler returns
+
+func (w *Writer) JSON(statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.respond(statusCode, "application/json", append(body, '\n'))
+}
+
+func (w *Writer) HTML(statusCode StatusCode, body string) error {
+	return w.respond(statusCode, "text/html; charset=utf-8", []byte(body))
+}
+
+func (w *Writer) Text(statusCode StatusCode, s string) error {
+	return w.respond(statusCode, "text/plain; charset=utf-8", []byte(s))
+}
+
+// Redirect points the client at location with a 3xx status, usually
+// 301, 302, 303, 307 or 308
+func (w *Writer) Redirect(statusCode StatusCode, location string) error {
+	if !statusCode.IsRedirect() {
+		return ERROR_INVALID_REDIRECT
+	}
+	w.Header().Set("Location", location, true)
+	w.WriteHeader(statusCode)
+	return nil
+}
+
+func (w *Writer) NoContent() error {
+	w.WriteHeader(StatusNoContent)
+	return nil
+}
+
+func (w *Writer) respond(statusCode StatusCode, contentType string, body []byte) error {
+	if w.started {
+		return ERROR_RESPONSE_STARTED
+	}
+	w.Header().Set("Content-Type", contentType, true)
+	w.WriteHeader(statusCode)
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,55 @@
+package response
+
+import (
+	"fmt"
+	"net"
+)
+
+var ERROR_HIJACK_UNSUPPORTED = fmt.Errorf("connection can't be hijacked.")
+var ERROR_HIJACKED = fmt.Errorf("connection has been hijacked.")
+
+// Hijacker hands the connection over, buffered holds the bytes already
+// read from it past the request head
+type Hijacker func() (conn net.Conn, buffered []byte, err error)
+
+// SetHijacker lets the server offer the connection to handlers
+func (w *Writer) SetHijacker(hijacker Hijacker) {
+	w.hijacker = hijacker
+}
+
+// Hijack takes the connection over for protocols like WebSocket or
+// CONNECT tunnels. The response must not be started, anything buffered
+// with Write is dropped and the writer can't be used afterwards. The
+// server no longer reads from, times out or closes the connection, that's
+// up to the caller now
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	switch {
+	case w.hijacked:
+		return nil, nil, ERROR_HIJACKED
+	case w.hijacker == nil:
+		return nil, nil, ERROR_HIJACK_UNSUPPORTED
+	case w.started:
+		return nil, nil, ERROR_RESPONSE_STARTED
+	}
+
+	conn, buffered, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.writer = hijackedWriter{}
+	w.buf.Reset()
+	return conn, buffered, nil
+}
+
+// hijackedWriter replaces the connection once it's taken over so any
+// later write through the Writer fails instead of corrupting the stream
+type hijackedWriter struct{}
+
+func (hijackedWriter) Write(p []byte) (int, error) {
+	return 0, ERROR_HIJACKED
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
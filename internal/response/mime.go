@@ -0,0 +1,104 @@
+package response
+
+import (
+	"path"
+	"strings"
+)
+
+var typesByExtension = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".xml":   "text/xml; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".avif":  "image/avif",
+	".ico":   "image/x-icon",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+	".ogg":   "audio/ogg",
+	".wav":   "audio/wav",
+	".pdf":   "application/pdf",
+	".zip":   "application/zip",
+	".gz":    "application/gzip",
+	".wasm":  "application/wasm",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+}
+
+// TypeByExtension guesses the content type from the extension of name,
+// it returns an empty string when the extension is unknown
+func TypeByExtension(name string) string {
+	return typesByExtension[strings.ToLower(path.Ext(name))]
+}
+
+// signatures are magic numbers of common binary formats, a subset of the
+// WHATWG MIME Sniffing algorithm
+var signatures = []struct {
+	offset      int
+	prefix      string
+	contentType string
+}{
+	{0, "%PDF-", "application/pdf"},
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{0, "PK\x03\x04", "application/zip"},
+	{0, "\x1f\x8b\x08", "application/gzip"},
+	{0, "\x1a\x45\xdf\xa3", "video/webm"},
+	{0, "ID3", "audio/mpeg"},
+	{0, "OggS\x00", "audio/ogg"},
+	{0, "\x00asm", "application/wasm"},
+	{0, "wOFF", "font/woff"},
+	{0, "wOF2", "font/woff2"},
+	{4, "ftyp", "video/mp4"},
+}
+
+// sniffLen is how many bytes DetectContentType looks at
+const sniffLen = 512
+
+// DetectContentType guesses the content type from the first bytes of the
+// content, it falls back to text/plain or application/octet-stream
+func DetectContentType(data []byte) string {
+	data = data[:min(len(data), sniffLen)]
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.prefix) && string(data[sig.offset:sig.offset+len(sig.prefix)]) == sig.prefix {
+			return sig.contentType
+		}
+	}
+	if len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+		return "image/webp"
+	}
+
+	text := strings.ToLower(strings.TrimLeft(string(data), " \t\r\n\f"))
+	for _, prefix := range []string{"<!doctype html", "<html", "<head", "<body", "<script", "<div", "<p", "<h1", "<title"} {
+		if strings.HasPrefix(text, prefix) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if strings.HasPrefix(text, "<?xml") {
+		return "text/xml; charset=utf-8"
+	}
+
+	for _, ch := range data {
+		// control bytes other than whitespace mean binary data
+		if ch < 0x20 && ch != '\t' && ch != '\n' && ch != '\r' && ch != '\f' && ch != 0x1b {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
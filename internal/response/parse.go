@@ -0,0 +1,337 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/chunked"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/message"
+)
+
+type parserState string
+
+const (
+	StateInit    parserState = "init"
+	StateHeaders parserState = "headers"
+	StateBody    parserState = "body"
+	StateDone    parserState = "done"
+	StateError   parserState = "error"
+)
+
+var ERROR_MALFORMED_STATUS_LINE = fmt.Errorf("malformed status line.")
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content length.")
+var ERROR_RESPONSE_IN_ERROR_STATE = fmt.Errorf("response in error state.")
+var ERROR_HEAD_TOO_LARGE = fmt.Errorf("response head too large.")
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// Response is a response read from a connection, the counterpart of
+// request.Request for clients
+type Response struct {
+	StatusLine StatusLine
+	Headers    *headers.Headers
+	Body       []byte
+	// Trailers holds the trailer section of a chunked body, it is filled
+	// once the body was read
+	Trailers *headers.Headers
+	// ContentLength is -1 when the length of the body isn't known up front
+	ContentLength int64
+	state         parserState
+
+	// method is the method of the request, a response to HEAD has no body
+	method string
+	in     *message.Reader
+	body   message.Body
+}
+
+func newResponse(reader io.Reader, buffered []byte, method string) *Response {
+	return &Response{
+		state:         StateInit,
+		Headers:       headers.NewHeaders(),
+		Body:          []byte(""),
+		ContentLength: -1,
+		method:        method,
+		in:            message.NewReader(reader, buffered),
+	}
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	read := 0
+
+outer:
+	for {
+		currentData := data[read:]
+		if len(currentData) == 0 {
+			break outer
+		}
+
+		switch r.state {
+		case StateError:
+			return 0, ERROR_RESPONSE_IN_ERROR_STATE
+
+		case StateInit:
+			sl, n, err := parseStatusLine(currentData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if n == 0 {
+				break outer
+			}
+			r.StatusLine = *sl
+			read += n
+			r.state = StateHeaders
+
+		case StateHeaders:
+			n, done, err := r.Headers.Parse(currentData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if n == 0 {
+				break outer
+			}
+			read += n
+			if !done {
+				continue
+			}
+
+			status := r.StatusLine.StatusCode
+			if status.IsInformational() && status != StatusSwitchingProtocols {
+				// interim responses are skipped, the final one follows
+				r.Headers = headers.NewHeaders()
+				r.state = StateInit
+				continue
+			}
+			if err := r.framing(); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			// stop at the end of the head so the body can be read separately
+			break outer
+
+		case StateBody:
+			n, data, err := r.body.Next(currentData, len(currentData))
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			r.Body = append(r.Body, data...)
+			r.addBody()
+			read += n
+			if n == 0 {
+				break outer
+			}
+
+		case StateDone:
+			break outer
+
+		default:
+			panic("something went wrong in parse method")
+		}
+	}
+	return read, nil
+}
+
+// framing works out how the body is delimited (RFC 9112 §6.3)
+func (r *Response) framing() error {
+	if value, ok := r.Headers.Get("Content-Length"); ok {
+		length, ok := message.ParseContentLength(value)
+		if !ok {
+			return ERROR_INVALID_CONTENT_LENGTH
+		}
+		r.ContentLength = length
+	}
+
+	status := r.StatusLine.StatusCode
+	te, hasTE := r.Headers.Get("Transfer-Encoding")
+	switch {
+	case r.method == "HEAD" || status == StatusNoContent || status == StatusNotModified ||
+		status == StatusSwitchingProtocols:
+		// the length, if any, describes the representation and not a body
+		r.state = StateDone
+	case r.method == "CONNECT" && status.IsSuccess():
+		// the connection turns into a tunnel right after the head
+		r.ContentLength = -1
+		r.state = StateDone
+	case hasTE && strings.EqualFold(lastToken(te), "chunked"):
+		r.ContentLength = -1
+		r.body.Chunked = chunked.NewDecoder()
+		r.Trailers = r.body.Chunked.Trailers
+		r.state = StateBody
+	case hasTE || r.ContentLength < 0:
+		// the body ends when the server closes the connection
+		r.ContentLength = -1
+		r.body.UntilEOF = true
+		r.state = StateBody
+	case r.ContentLength == 0:
+		r.state = StateDone
+	default:
+		r.body.Length = r.ContentLength
+		r.state = StateBody
+	}
+	return nil
+}
+
+func lastToken(value string) string {
+	tokens := strings.Split(value, ",")
+	return strings.TrimSpace(tokens[len(tokens)-1])
+}
+
+// addBody finishes the body once it is complete
+func (r *Response) addBody() {
+	if r.body.Done() {
+		r.state = StateDone
+	}
+}
+
+// parseStatusLine parses a status line like "HTTP/1.1 200 OK", the reason
+// phrase may be empty (RFC 9112 §4)
+func parseStatusLine(data []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(data, CRLF)
+	if idx == -1 {
+		return nil, 0, nil
+	}
+	line := string(data[:idx])
+	read := idx + len(CRLF)
+
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, 0, ERROR_MALFORMED_STATUS_LINE
+	}
+	version, ok = strings.CutPrefix(version, "HTTP/")
+	if !ok || len(version) != 3 || !strings.HasPrefix(version, "1.") || version[2] < '0' || version[2] > '9' {
+		return nil, 0, ERROR_MALFORMED_STATUS_LINE
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	status, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 || !StatusCode(status).Valid() {
+		return nil, 0, ERROR_MALFORMED_STATUS_LINE
+	}
+
+	return &StatusLine{
+		HttpVersion:  version,
+		StatusCode:   StatusCode(status),
+		ReasonPhrase: reason,
+	}, read, nil
+}
+
+func (r *Response) inHead() bool {
+	return r.state == StateInit || r.state == StateHeaders
+}
+
+func (r *Response) done() bool {
+	return r.state == StateDone || r.state == StateError
+}
+
+// KeepAlive reports whether the connection can carry another request once
+// the body was read, a close-delimited body uses it up
+func (r *Response) KeepAlive() bool {
+	if r.body.UntilEOF || r.Headers.HasToken("Connection", "close") {
+		return false
+	}
+	if r.StatusLine.HttpVersion == "1.0" {
+		return r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return true
+}
+
+// BodyRead reports whether the whole body has been read from the connection
+func (r *Response) BodyRead() bool {
+	return r.done()
+}
+
+// ReadBody reads the rest of the body into r.Body and returns it
+func (r *Response) ReadBody() ([]byte, error) {
+	if err := r.readUntil(r.done); err != nil {
+		return nil, err
+	}
+	return r.Body, nil
+}
+
+// BodyReader streams the rest of the body without keeping it in r.Body
+func (r *Response) BodyReader() io.Reader {
+	return &bodyReader{r: r}
+}
+
+type bodyReader struct {
+	r *Response
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	switch r.state {
+	case StateDone:
+		return 0, io.EOF
+	case StateError:
+		return 0, ERROR_RESPONSE_IN_ERROR_STATE
+	}
+
+	n, err := r.in.ReadBody(p, &r.body)
+	if err != nil && err != io.EOF {
+		r.state = StateError
+		return 0, err
+	}
+	r.addBody()
+	return n, err
+}
+
+// Buffered returns the bytes read from the connection but not parsed yet,
+// like the start of the next response on a reused connection
+func (r *Response) Buffered() []byte {
+	return r.in.Buffered()
+}
+
+// readUntil parses the buffered bytes and reads more from the connection
+// until stop returns true
+func (r *Response) readUntil(stop func() bool) error {
+	err := r.in.ReadUntil(r.parse, r.inHead, stop)
+	switch {
+	case err == io.EOF && r.state == StateBody && r.body.UntilEOF:
+		r.body.End()
+		r.state = StateDone
+		return nil
+	case err == io.EOF && r.state != StateInit:
+		return io.ErrUnexpectedEOF
+	case errors.Is(err, message.ERROR_HEAD_TOO_LARGE):
+		r.state = StateError
+		return ERROR_HEAD_TOO_LARGE
+	}
+	return err
+}
+
+// HeadFromBuffered reads the status line and headers of the response to
+// a request with the given method, buffered holds bytes already read from
+// the connection. The body is left on the connection until ReadBody is called
+func HeadFromBuffered(reader io.Reader, buffered []byte, method string) (*Response, error) {
+	response := newResponse(reader, buffered, method)
+	headDone := func() bool {
+		return response.state != StateInit && response.state != StateHeaders
+	}
+	if err := response.readUntil(headDone); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ResponseFromReader reads a whole response to a request with the given
+// method, which decides whether there is a body
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	response, err := HeadFromBuffered(reader, nil, method)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := response.ReadBody(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
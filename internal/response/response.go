@@ -1,60 +1,181 @@
 package response
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strconv"
+	"tcpToHttp/internal/cookie"
 	"tcpToHttp/internal/headers"
 )
 
+var CRLF = []byte("\r\n")
+var ERROR_NOT_INFORMATIONAL = fmt.Errorf("not an informational status code.")
+var ERROR_RESPONSE_STARTED = fmt.Errorf("response already started.")
+var ERROR_INVALID_STATUS = fmt.Errorf("invalid status code.")
+var ERROR_INFORMATIONAL_STATUS = fmt.Errorf("informational status code is not a final response.")
+var ERROR_BODY_NOT_ALLOWED = fmt.Errorf("response status does not allow a body.")
+
+// DefaultBufferSize is how much of a body written with Write is held
+// back before the writer switches to chunked encoding
+const DefaultBufferSize = 32 * 1024
+
+// Writer writes a response either explicitly with WriteStatusLine,
+// WriteHeaders and WriteBody, or in buffered mode with Header, WriteHeader
+// and Write where framing is worked out when the handler is done
 type Writer struct {
-	writer io.Writer
+	writer    io.Writer
+	version   string
+	keepAlive bool
+	started   bool
+	chunked   bool
+	status    StatusCode
+	head      bool
+
+	dates      *DateCache
+	serverName string
+	cookies    []string
+	hijacker   Hijacker
+	hijacked   bool
+	transport  Transport
+
+	// encoding is the negotiated content coding, enc compresses the body
+	// of the current response when it's in use
+	encoding *Encoding
+	enc      io.WriteCloser
+
+	// buffered mode
+	header      *headers.Headers
+	pending     StatusCode
+	buf         bytes.Buffer
+	bufferSize  int
+	autoChunked bool
 }
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		writer: w,
+		writer:     w,
+		version:    "1.1",
+		bufferSize: DefaultBufferSize,
 	}
 }
 
-type StatusCode uint16
+// SetVersion sets the protocol version echoed in the status line,
+// HTTP/1.0 clients never get chunked responses
+func (w *Writer) SetVersion(version string) {
+	if version == "1.0" {
+		w.version = version
+	} else {
+		w.version = "1.1"
+	}
+}
 
-const (
-	StatusOK               StatusCode = 200
-	StatusBadReq           StatusCode = 400
-	StatusNotFound         StatusCode = 404
-	StatusMethodNotAllowed StatusCode = 405
-	StatusServerError      StatusCode = 500
-)
+// SetKeepAlive sets whether the connection should stay open after the
+// response, the writer may still decide to close it (e.g. close-delimited bodies)
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// SetDate makes the writer add a Date header to every final response
+func (w *Writer) SetDate(dates *DateCache) {
+	w.dates = dates
+}
+
+// SetServerName sets the value of the Server header, empty means none
+func (w *Writer) SetServerName(name string) {
+	w.serverName = name
+}
+
+// SetHeadRequest makes the writer drop the body of a response to HEAD
+// while keeping the headers the same as for GET
+func (w *Writer) SetHeadRequest(head bool) {
+	w.head = head
+}
+
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive
+}
 
-var statusMap = map[StatusCode]string{
-	StatusOK:               "OK",
-	StatusBadReq:           "Bad Request",
-	StatusNotFound:         "Not Found",
-	StatusMethodNotAllowed: "Method not allowed",
-	StatusServerError:      "Internal Server Error",
+// Started reports whether the status line has already been written
+func (w *Writer) Started() bool {
+	return w.started
 }
 
+// WriteStatusLine writes the final status line, the reason phrase is left
+// empty for unregistered codes as allowed by RFC 9112 §4
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusText, ok := statusMap[statusCode]
-	if !ok {
-		statusText = "Unknown Status"
+	if !statusCode.Valid() {
+		return ERROR_INVALID_STATUS
+	}
+	if statusCode.IsInformational() {
+		return ERROR_INFORMATIONAL_STATUS
+	}
+	if w.started {
+		return ERROR_RESPONSE_STARTED
 	}
 
-	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, statusText)
+	w.started = true
+	w.status = statusCode
+	if w.transport != nil {
+		// the status goes out with the headers
+		return nil
+	}
+	statusLine := fmt.Sprintf("HTTP/%s %d %s\r\n", w.version, statusCode, StatusText(statusCode))
 	_, err := w.writer.Write([]byte(statusLine))
 	return err
 }
 
+// WriteInformational writes an interim 1xx response like 103 Early Hints,
+// it can be called any number of times before the final status line.
+// HTTP/1.0 clients don't understand 1xx responses so nothing is written
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if !statusCode.IsInformational() || statusCode == StatusSwitchingProtocols {
+		return ERROR_NOT_INFORMATIONAL
+	}
+	if w.started {
+		return ERROR_RESPONSE_STARTED
+	}
+	if w.transport != nil {
+		if h == nil {
+			h = headers.NewHeaders()
+		}
+		return w.transport.WriteHead(statusCode, h)
+	}
+	if w.version == "1.0" {
+		return nil
+	}
+
+	b := fmt.Appendf(nil, "HTTP/%s %d %s\r\n", w.version, statusCode, StatusText(statusCode))
+	if h != nil {
+		h.ForEach(func(k, v string) {
+			b = fmt.Appendf(b, "%s: %s\r\n", k, v)
+		})
+	}
+	b = append(b, CRLF...)
+	_, err := w.writer.Write(b)
+	return err
+}
+
+// WriteContinue tells a client waiting on Expect: 100-continue to send the body
+func (w *Writer) WriteContinue() error {
+	return w.WriteInformational(StatusContinue, nil)
+}
+
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", strconv.Itoa(contentLen), false)
-	h.Set("Connection", "close", false)
 	h.Set("Content-Type", "text/plain", false)
 	return h
 }
 
+// WriteHeaders writes the header section, it also decides the framing of
+// the body and whether the connection can stay open afterwards
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
+	w.prepareHeaders(&headers)
+	if w.transport != nil {
+		return w.transport.WriteHead(w.status, &headers)
+	}
+
 	b := []byte{}
 	headers.ForEach(func(k, v string) {
 		b = fmt.Appendf(b, "%s: %s\r\n", k, v)
@@ -64,7 +185,84 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return err
 }
 
+func (w *Writer) prepareHeaders(h *headers.Headers) {
+	if w.transport == nil && h.HasToken("Connection", "close") {
+		w.keepAlive = false
+	}
+
+	size := -1
+	if _, ok := h.Get("Content-Length"); ok {
+		size = h.GetInt("Content-Length", -1)
+	}
+	if w.useEncoding(w.status, h, size) {
+		// the encoded length isn't known up front so the body is chunked
+		w.setEncoded(h)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked", true)
+		w.enc = w.encoding.New(chunkWriter{w})
+	}
+
+	if w.started && !w.status.AllowsBody() {
+		// there is no body to frame, a 304 may still describe the
+		// length of the selected representation
+		if w.status != StatusNotModified {
+			h.Delete("Content-Length")
+		}
+		h.Delete("Transfer-Encoding")
+	} else if w.transport != nil {
+		// the transport frames the body itself
+		h.Delete("Transfer-Encoding")
+	} else if h.HasToken("Transfer-Encoding", "chunked") {
+		if w.version == "1.0" {
+			// HTTP/1.0 has no chunked coding, the body is delimited by
+			// closing the connection instead
+			h.Delete("Transfer-Encoding")
+			h.Delete("Trailer")
+			w.keepAlive = false
+		} else {
+			w.chunked = true
+		}
+	} else if _, ok := h.Get("Content-Length"); !ok {
+		w.keepAlive = false
+	}
+
+	for _, value := range w.cookies {
+		h.Set("Set-Cookie", value, false)
+	}
+	w.cookies = nil
+
+	if _, ok := h.Get("Date"); !ok && w.dates != nil {
+		h.Set("Date", w.dates.Date(), true)
+	}
+	if _, ok := h.Get("Server"); !ok && w.serverName != "" {
+		h.Set("Server", w.serverName, true)
+	}
+
+	if w.transport != nil {
+		for _, name := range connectionHeaders {
+			h.Delete(name)
+		}
+		return
+	}
+
+	h.Delete("Connection")
+	if !w.keepAlive {
+		h.Set("Connection", "close", true)
+	} else if w.version == "1.0" {
+		h.Set("Connection", "keep-alive", true)
+	}
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
+	if len(p) > 0 && w.started && !w.status.AllowsBody() {
+		return 0, ERROR_BODY_NOT_ALLOWED
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	if w.head {
+		return len(p), nil
+	}
 	n, err := w.writer.Write(p)
 	if err != nil {
 		return 0, err
@@ -72,8 +270,242 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	return n, err
 }
 
+// WriteChunkedBody writes p as a single chunk, for HTTP/1.0 clients the
+// bytes are written as is
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	return w.writer.Write(p)
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.started && !w.status.AllowsBody() {
+		return 0, ERROR_BODY_NOT_ALLOWED
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.writeChunk(p)
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.head {
+		return len(p), nil
+	}
+	if !w.chunked {
+		return w.writer.Write(p)
+	}
+
+	b := fmt.Appendf(nil, "%x\r\n", len(p))
+	b = append(b, p...)
+	b = append(b, CRLF...)
+	if _, err := w.writer.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-// func (w *Writer) WriteChunkedBodyDone() (int, error)
+// chunkWriter lets an encoder write its output as chunks
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	return cw.w.writeChunk(p)
+}
+
+// WriteChunkedBodyDone writes the last chunk, it must be followed by
+// WriteTrailers even if there are none
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.enc != nil {
+		enc := w.enc
+		w.enc = nil
+		if err := enc.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if !w.chunked || w.head {
+		return 0, nil
+	}
+	return w.writer.Write([]byte("0\r\n"))
+}
+
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.transport != nil && !w.head {
+		return w.transport.WriteTrailers(&h)
+	}
+	if !w.chunked || w.head {
+		return nil
+	}
+
+	b := []byte{}
+	h.ForEach(func(k, v string) {
+		b = fmt.Appendf(b, "%s: %s\r\n", k, v)
+	})
+	b = fmt.Append(b, "\r\n")
+	_, err := w.writer.Write(b)
+	return err
+}
+
+// SetCookie adds a Set-Cookie field line to the response, it works with
+// both buffered and explicit responses as long as the head isn't sent yet
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.started {
+		return ERROR_RESPONSE_STARTED
+	}
+	value, err := c.String()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, value)
+	return nil
+}
+
+// SetBufferSize sets how many body bytes Write buffers before sending the
+// head with chunked encoding, 0 disables the switch
+func (w *Writer) SetBufferSize(size int) {
+	w.bufferSize = size
+}
+
+// Header returns the headers sent in buffered mode, Content-Length and
+// Transfer-Encoding are set by the writer
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// WriteHeader sets the status used in buffered mode, it defaults to 200
+func (w *Writer) WriteHeader(statusCode StatusCode) {
+	w.pending = statusCode
+}
+
+// Write buffers p as part of the body, once the buffer grows past the
+// buffer size the head is sent and the body continues in chunks.
+// After an explicit WriteStatusLine it writes straight to the body
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ERROR_HIJACKED
+	}
+	if w.started {
+		if w.chunked {
+			return w.WriteChunkedBody(p)
+		}
+		return w.WriteBody(p)
+	}
+
+	w.buf.Write(p)
+	if w.bufferSize > 0 && w.buf.Len() > w.bufferSize {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the head with chunked encoding if it wasn't sent yet and
+// writes whatever is buffered as a chunk
+func (w *Writer) Flush() error {
+	if !w.started {
+		h := w.bufferedHeaders()
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked", true)
+		if err := w.WriteStatusLine(w.pendingStatus()); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(*h); err != nil {
+			return err
+		}
+		w.autoChunked = true
+	}
+
+	if w.buf.Len() > 0 {
+		_, err := w.WriteChunkedBody(w.buf.Bytes())
+		w.buf.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Finish completes a buffered response, the server calls it after the
+// handler returns. A response written explicitly or a hijacked connection
+// is left untouched
+func (w *Writer) Finish() error {
+	if w.hijacked {
+		return nil
+	}
+	if w.autoChunked {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if w.autoChunked || w.enc != nil {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return w.WriteTrailers(*headers.NewHeaders())
+	}
+	if w.started {
+		return nil
+	}
+
+	status := w.pendingStatus()
+	h := w.bufferedHeaders()
+	h.Delete("Transfer-Encoding")
+	if w.useEncoding(status, h, w.buf.Len()) {
+		// the whole body is known so it's encoded here and keeps a Content-Length
+		encoded, err := w.encodeAll(w.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		w.buf.Reset()
+		w.buf.Write(encoded)
+		w.setEncoded(h)
+	}
+	if status.AllowsBody() {
+		h.Set("Content-Length", strconv.Itoa(w.buf.Len()), true)
+	}
+	if err := w.WriteStatusLine(status); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	if !status.AllowsBody() {
+		return nil
+	}
+	_, err := w.WriteBody(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// Buffered returns the status and body of a buffered response that hasn't
+// been sent yet, middleware can use it after the handler returns. There is
+// none once the connection was hijacked
+func (w *Writer) Buffered() (StatusCode, []byte, bool) {
+	if w.started || w.hijacked {
+		return 0, nil, false
+	}
+	return w.pendingStatus(), w.buf.Bytes(), true
+}
+
+func (w *Writer) pendingStatus() StatusCode {
+	if w.pending == 0 {
+		return StatusOK
+	}
+	return w.pending
+}
+
+func (w *Writer) bufferedHeaders() *headers.Headers {
+	h := w.Header()
+	if _, ok := h.Get("Content-Type"); !ok && w.buf.Len() > 0 {
+		h.Set("Content-Type", "text/plain", true)
+	}
+	return h
+}
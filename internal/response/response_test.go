@@ -0,0 +1,709 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"tcpToHttp/internal/cookie"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterChunked(t *testing.T) {
+	// Test: HTTP/1.1 chunked body with trailers
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetKeepAlive(true)
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked", true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := GetDefaultHeaders(0)
+	trailers.Delete("Content-Length")
+	trailers.Delete("Content-Type")
+	trailers.Set("X-Done", "yes", true)
+	require.NoError(t, w.WriteTrailers(*trailers))
+	assert.True(t, w.KeepAlive())
+	assert.Contains(t, buf.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, buf.String(), "\r\n\r\n5\r\nhello\r\n0\r\nx-done: yes\r\n\r\n")
+
+	// Test: HTTP/1.0 falls back to a close-delimited body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	w.SetKeepAlive(true)
+	h = GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked", true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	assert.Contains(t, buf.String(), "HTTP/1.0 200 OK\r\n")
+	assert.Contains(t, buf.String(), "connection: close\r\n")
+	assert.NotContains(t, buf.String(), "transfer-encoding")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+}
+
+func TestWriterInformational(t *testing.T) {
+	// Test: 103 Early Hints followed by the final response
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	hints := GetDefaultHeaders(0)
+	hints.Delete("Content-Length")
+	hints.Delete("Content-Type")
+	hints.Set("Link", "</style.css>; rel=preload; as=style", true)
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+	assert.False(t, w.Started())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\nHTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: No interim responses after the final status line
+	require.ErrorIs(t, w.WriteContinue(), ERROR_RESPONSE_STARTED)
+
+	// Test: 1xx responses are not sent to HTTP/1.0 clients
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteContinue())
+	assert.Equal(t, "", buf.String())
+
+	// Test: Only 1xx codes are interim responses
+	require.ErrorIs(t, w.WriteInformational(StatusOK, nil), ERROR_NOT_INFORMATIONAL)
+}
+
+func TestStatusLine(t *testing.T) {
+	// Test: Registered codes use the standard reason phrase
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusTooManyRequests))
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", buf.String())
+	assert.Equal(t, "Method Not Allowed", StatusText(StatusMethodNotAllowed))
+
+	// Test: Unregistered codes have an empty reason phrase
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(599))
+	assert.Equal(t, "HTTP/1.1 599 \r\n", buf.String())
+	assert.Equal(t, "", StatusText(599))
+
+	// Test: Invalid and interim codes are not final status lines
+	w = NewWriter(&bytes.Buffer{})
+	require.ErrorIs(t, w.WriteStatusLine(42), ERROR_INVALID_STATUS)
+	require.ErrorIs(t, w.WriteStatusLine(StatusContinue), ERROR_INFORMATIONAL_STATUS)
+
+	// Test: Class helpers
+	assert.True(t, StatusCreated.IsSuccess())
+	assert.True(t, StatusPermanentRedirect.IsRedirect())
+	assert.True(t, StatusNotFound.IsClientError())
+	assert.True(t, StatusBadGateway.IsServerError())
+	assert.True(t, StatusEarlyHints.IsInformational())
+	assert.False(t, StatusOK.IsRedirect())
+
+	// Test: 204 and 304 responses carry no body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+	assert.NotContains(t, buf.String(), "content-length")
+	assert.True(t, w.KeepAlive())
+
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.ErrorIs(t, err, ERROR_BODY_NOT_ALLOWED)
+}
+
+func TestWriterBuffered(t *testing.T) {
+	// Test: Content-Length is computed when the handler is done
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetKeepAlive(true)
+	w.Header().Set("Content-Type", "text/html", true)
+	w.WriteHeader(StatusCreated)
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, "", buf.String())
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 11\r\n")
+	assert.Contains(t, buf.String(), "content-type: text/html\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello world"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Switching to chunked once the buffer size is exceeded
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetBufferSize(4)
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	assert.False(t, w.Started())
+	_, err = w.Write([]byte("defg"))
+	require.NoError(t, err)
+	assert.True(t, w.Started())
+	_, err = w.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, buf.String(), "content-length")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n7\r\nabcdefg\r\n2\r\nhi\r\n0\r\n\r\n"))
+
+	// Test: Explicit flush
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	_, err = w.Write([]byte("first"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nfirst\r\n"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nfirst\r\n0\r\n\r\n"))
+
+	// Test: Handler that writes nothing gets an empty 200
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 0\r\n")
+
+	// Test: A buffered 304 gets no Content-Length of its empty body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Header().Set("ETag", `"v1"`, true)
+	w.WriteHeader(StatusNotModified)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, buf.String(), "content-length")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+
+	// Test: Explicit responses are left alone by Finish
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(2)))
+	_, err = w.Write([]byte("ok"))
+	require.NoError(t, err)
+	before := buf.String()
+	require.NoError(t, w.Finish())
+	assert.Equal(t, before, buf.String())
+}
+
+func TestWriterHelpers(t *testing.T) {
+	// Test: JSON
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.JSON(StatusCreated, map[string]int{"id": 7}))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, buf.String(), "content-type: application/json\r\n")
+	assert.Contains(t, buf.String(), "content-length: 9\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n{\"id\":7}\n"))
+
+	// Test: Text
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Text(StatusOK, "hi"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhi"))
+
+	// Test: Redirect
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Redirect(StatusSeeOther, "/login"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 303 See Other\r\n"))
+	assert.Contains(t, buf.String(), "location: /login\r\n")
+	assert.Contains(t, buf.String(), "content-length: 0\r\n")
+	require.ErrorIs(t, NewWriter(&bytes.Buffer{}).Redirect(StatusOK, "/"), ERROR_INVALID_REDIRECT)
+
+	// Test: NoContent
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.NoContent())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nconnection: close\r\n\r\n", buf.String())
+}
+
+func TestWriterDateAndServer(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 14, 5, 7, 0, time.FixedZone("CET", 3600))
+	calls := 0
+	dates := NewDateCache(func() time.Time {
+		calls++
+		return now
+	})
+
+	// Test: Date in IMF-fixdate and the configured Server header
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetDate(dates)
+	w.SetServerName("tcpToHttp")
+	require.NoError(t, w.NoContent())
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "date: Sat, 09 Mar 2024 13:05:07 GMT\r\n")
+	assert.Contains(t, buf.String(), "server: tcpToHttp\r\n")
+
+	// Test: The formatted value is cached within the same second
+	first := dates.cached.Load()
+	assert.Equal(t, "Sat, 09 Mar 2024 13:05:07 GMT", dates.Date())
+	assert.Same(t, first, dates.cached.Load())
+	now = now.Add(time.Second)
+	assert.Equal(t, "Sat, 09 Mar 2024 13:05:08 GMT", dates.Date())
+	assert.Equal(t, 3, calls)
+
+	// Test: Handlers can set their own Date and Server
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetDate(dates)
+	w.SetServerName("tcpToHttp")
+	w.Header().Set("Server", "custom", true)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "server: custom\r\n")
+	assert.NotContains(t, buf.String(), "tcpToHttp")
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	// Test: Each cookie gets its own Set-Cookie line
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/"}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	assert.Equal(t, cookie.ERROR_INVALID_COOKIE_NAME, w.SetCookie(&cookie.Cookie{Name: "a b"}))
+	require.NoError(t, w.Text(StatusOK, "ok"))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "set-cookie: a=1; Path=/\r\n")
+	assert.Contains(t, buf.String(), "set-cookie: b=2; HttpOnly\r\n")
+	assert.NotContains(t, buf.String(), "a=1; Path=/, ")
+
+	// Test: Cookies are added to explicit heads too
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1"}))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+	assert.Contains(t, buf.String(), "set-cookie: a=1\r\n")
+
+	// Test: Cookies after the head are rejected
+	assert.Equal(t, ERROR_RESPONSE_STARTED, w.SetCookie(&cookie.Cookie{Name: "a"}))
+}
+
+func TestWriterHijack(t *testing.T) {
+	// Test: No hijacker installed
+	w := NewWriter(&bytes.Buffer{})
+	_, _, err := w.Hijack()
+	assert.Equal(t, ERROR_HIJACK_UNSUPPORTED, err)
+
+	// Test: The response already started
+	server, client := net.Pipe()
+	defer client.Close()
+	hijacker := func() (net.Conn, []byte, error) {
+		return server, []byte("rest"), nil
+	}
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	_, _, err = w.Hijack()
+	assert.Equal(t, ERROR_RESPONSE_STARTED, err)
+
+	// Test: The writer is unusable after a hijack
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHijacker(hijacker)
+	conn, rest, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, "rest", string(rest))
+	assert.True(t, w.Hijacked())
+	_, _, err = w.Hijack()
+	assert.Equal(t, ERROR_HIJACKED, err)
+	_, err = w.Write([]byte("x"))
+	assert.Equal(t, ERROR_HIJACKED, err)
+	assert.Equal(t, ERROR_HIJACKED, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.Finish())
+	assert.Empty(t, buf.String())
+}
+
+func gzipEncoding(minSize int) *Encoding {
+	return &Encoding{
+		Name: "gzip",
+		New: func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+		MinSize: minSize,
+		Skip: func(contentType string) bool {
+			return strings.HasPrefix(contentType, "video/")
+		},
+	}
+}
+
+func gunzip(t *testing.T, p []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestWriterEncoding(t *testing.T) {
+	body := strings.Repeat("compress me ", 50)
+
+	// Test: Buffered body is encoded with an adjusted Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetEncoding(gzipEncoding(100))
+	require.NoError(t, w.Text(StatusOK, body))
+	require.NoError(t, w.Finish())
+	head, encoded, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	head += "\r\n"
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.Contains(t, head, "vary: Accept-Encoding\r\n")
+	assert.Contains(t, head, fmt.Sprintf("content-length: %d\r\n", len(encoded)))
+	assert.Equal(t, body, gunzip(t, []byte(encoded)))
+
+	// Test: A strong ETag is weakened once the body is encoded
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetEncoding(gzipEncoding(100))
+	w.Header().Set("ETag", `"v1"`, true)
+	require.NoError(t, w.Text(StatusOK, body))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "etag: W/\"v1\"\r\n")
+
+	// Test: Bodies below the minimum size are sent as is
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetEncoding(gzipEncoding(100))
+	require.NoError(t, w.Text(StatusOK, "tiny"))
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "content-encoding")
+	assert.Contains(t, buf.String(), "vary: Accept-Encoding\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\ntiny"))
+
+	// Test: Already compressed content types are skipped
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetEncoding(gzipEncoding(0))
+	w.Header().Set("Content-Type", "video/mp4", true)
+	_, err := w.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "content-encoding")
+	assert.NotContains(t, buf.String(), "vary")
+
+	// Test: Explicit responses switch to chunked encoding
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetEncoding(gzipEncoding(100))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(len(body))))
+	_, err = w.WriteBody([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	head, chunked, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	head += "\r\n"
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, head, "content-encoding: gzip\r\n")
+	assert.NotContains(t, head, "content-length")
+
+	decoded := []byte{}
+	rest := chunked
+	for {
+		sizeLine, after, _ := strings.Cut(rest, "\r\n")
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			assert.Equal(t, "\r\n", after)
+			break
+		}
+		decoded = append(decoded, after[:size]...)
+		rest = after[size+2:]
+	}
+	assert.Equal(t, body, gunzip(t, decoded))
+}
+
+func newRequest(t *testing.T, head string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(head + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestServeContent(t *testing.T) {
+	content := "0123456789abcdefghij"
+	modtime := time.Date(2024, time.March, 9, 13, 5, 7, 0, time.UTC)
+	serve := func(head string) string {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		err := ServeContent(w, newRequest(t, head), "notes.txt", modtime, strings.NewReader(content))
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	// Test: Whole content
+	out := serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "accept-ranges: bytes\r\n")
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "last-modified: Sat, 09 Mar 2024 13:05:07 GMT\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+content))
+
+	// Test: Single range
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=2-5\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 2-5/20\r\n")
+	assert.Contains(t, out, "content-length: 4\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n2345"))
+
+	// Test: Suffix and open ended ranges
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=-3\r\n")
+	assert.Contains(t, out, "content-range: bytes 17-19/20\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhij"))
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=15-\r\n")
+	assert.Contains(t, out, "content-range: bytes 15-19/20\r\n")
+
+	// Test: Multiple ranges
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-1, 10-11\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	head, body, _ := strings.Cut(out, "\r\n\r\n")
+	head += "\r\n"
+	assert.Contains(t, head, "content-type: multipart/byteranges; boundary=")
+	assert.Contains(t, head, fmt.Sprintf("content-length: %d\r\n", len(body)))
+	assert.Contains(t, body, "Content-Range: bytes 0-1/20\r\n\r\n01\r\n")
+	assert.Contains(t, body, "Content-Range: bytes 10-11/20\r\n\r\nab\r\n")
+
+	// Test: Unsatisfiable range
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=50-60\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */20\r\n")
+
+	// Test: If-Range with the current date honours the range
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-0\r\nIf-Range: Sat, 09 Mar 2024 13:05:07 GMT\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: If-Range with an old date gets the whole content
+	out = serve("GET /notes.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-0\r\nIf-Range: Fri, 08 Mar 2024 13:05:07 GMT\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: HEAD gets the headers only
+	out = serve("HEAD /notes.txt HTTP/1.1\r\nHost: localhost\r\n")
+	assert.Contains(t, out, "content-length: 20\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modtime := time.Date(2024, time.March, 9, 13, 5, 7, 0, time.UTC)
+	etag := StrongETag([]byte("hello"))
+	check := func(head string) (bool, string) {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		w.Header().Set("Cache-Control", "max-age=60", true)
+		done, err := CheckPreconditions(w, newRequest(t, head), etag, modtime)
+		require.NoError(t, err)
+		return done, buf.String()
+	}
+
+	// Test: No conditions
+	done, _ := check("GET / HTTP/1.1\r\nHost: localhost\r\n")
+	assert.False(t, done)
+
+	// Test: If-None-Match hit gives 304 with the validators
+	done, out := check("GET / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"other\", W/" + etag + "\r\n")
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, "etag: "+etag+"\r\n")
+	assert.Contains(t, out, "cache-control: max-age=60\r\n")
+	assert.NotContains(t, out, "content-length")
+
+	// Test: If-None-Match on an unsafe method gives 412
+	done, out = check("POST / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: *\r\n")
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	done, _ = check("GET / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"other\"\r\nIf-Modified-Since: Sat, 09 Mar 2024 13:05:07 GMT\r\n")
+	assert.False(t, done)
+
+	// Test: If-Modified-Since in the old formats
+	done, out = check("GET / HTTP/1.1\r\nHost: localhost\r\nIf-Modified-Since: Saturday, 09-Mar-24 13:05:07 GMT\r\n")
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	done, _ = check("GET / HTTP/1.1\r\nHost: localhost\r\nIf-Modified-Since: Sat Mar  9 13:05:06 2024\r\n")
+	assert.False(t, done)
+
+	// Test: If-Match uses strong comparison
+	done, out = check("PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Match: W/" + etag + "\r\n")
+	assert.True(t, done)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+	done, _ = check("PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Match: \"a,b\", " + etag + "\r\n")
+	assert.False(t, done)
+
+	// Test: If-Unmodified-Since is ignored when If-Match is present
+	done, _ = check("PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Match: *\r\nIf-Unmodified-Since: Fri, 08 Mar 2024 13:05:07 GMT\r\n")
+	assert.False(t, done)
+	done, _ = check("PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Unmodified-Since: Fri, 08 Mar 2024 13:05:07 GMT\r\n")
+	assert.True(t, done)
+
+	// Test: "*" matches an existing resource that has no etag
+	w := NewWriter(&bytes.Buffer{})
+	done, err := CheckPreconditions(w, newRequest(t, "PUT / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: *\r\n"), "", time.Time{})
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, StatusPreconditionFailed, w.status)
+	w = NewWriter(&bytes.Buffer{})
+	done, err = CheckPreconditions(w, newRequest(t, "PUT / HTTP/1.1\r\nHost: localhost\r\nIf-Match: *\r\n"), "", time.Time{})
+	require.NoError(t, err)
+	assert.False(t, done)
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body
+	reader := &testutil.ChunkReader{
+		Data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\nContent-Type: text/plain\r\n\r\nhello, world!",
+		NumBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusOK, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, int64(13), r.ContentLength)
+	assert.Equal(t, "hello, world!", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	// Test: Chunked body with trailers
+	reader = &testutil.ChunkReader{
+		Data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n7;ext=1\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\n",
+		NumBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), r.ContentLength)
+	assert.Equal(t, "hello, world", string(r.Body))
+	sum, _ := r.Trailers.Get("X-Sum")
+	assert.Equal(t, "abc", sum)
+	assert.True(t, r.KeepAlive())
+
+	// Test: Body delimited by the end of the connection
+	reader = &testutil.ChunkReader{
+		Data:            "HTTP/1.0 200 OK\r\nServer: old\r\n\r\nuntil the end",
+		NumBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(r.Body))
+	assert.False(t, r.KeepAlive())
+
+	// Test: Interim responses are skipped, reason phrase may be empty
+	reader = &testutil.ChunkReader{
+		Data:            "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\nHTTP/1.1 599\r\nContent-Length: 2\r\n\r\nok",
+		NumBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(599), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+	_, ok := r.Headers.Get("Link")
+	assert.False(t, ok)
+	assert.Equal(t, "ok", string(r.Body))
+
+	// Test: 204 and 304 have no body whatever the headers say
+	for _, status := range []string{"204 No Content", "304 Not Modified"} {
+		reader = &testutil.ChunkReader{
+			Data:            "HTTP/1.1 " + status + "\r\nContent-Length: 10\r\n\r\n",
+			NumBytesPerRead: 2,
+		}
+		r, err = ResponseFromReader(reader, "GET")
+		require.NoError(t, err, status)
+		assert.Equal(t, "", string(r.Body), status)
+	}
+
+	// Test: The response to HEAD has no body whatever the headers say
+	reader = &testutil.ChunkReader{
+		Data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\n",
+		NumBytesPerRead: 3,
+	}
+	r, err = ResponseFromReader(reader, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), r.ContentLength)
+	assert.Equal(t, "", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	// Test: Body shorter than its Content-Length
+	reader = &testutil.ChunkReader{
+		Data:            "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+		NumBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Test: Malformed status lines
+	for _, line := range []string{"HTTP/2 200 OK", "HTTP/1.1 20 OK", "HTTP/1.1 abc OK", "ICY 200 OK"} {
+		reader = &testutil.ChunkReader{Data: line + "\r\n\r\n", NumBytesPerRead: 3}
+		_, err = ResponseFromReader(reader, "GET")
+		assert.Equal(t, ERROR_MALFORMED_STATUS_LINE, err, line)
+	}
+
+	// Test: Invalid Content-Length
+	reader = &testutil.ChunkReader{Data: "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n", NumBytesPerRead: 3}
+	_, err = ResponseFromReader(reader, "GET")
+	assert.Equal(t, ERROR_INVALID_CONTENT_LENGTH, err)
+
+	// Test: A head over the size limit
+	reader = &testutil.ChunkReader{
+		Data:            "HTTP/1.1 200 OK\r\nX-Big: " + strings.Repeat("a", 100_000) + "\r\n\r\n",
+		NumBytesPerRead: 1024,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	assert.Equal(t, ERROR_HEAD_TOO_LARGE, err)
+}
+
+func TestHeadFromBuffered(t *testing.T) {
+	// Test: HEAD response keeps the next response buffered
+	reader := &testutil.ChunkReader{
+		Data:            "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello",
+		NumBytesPerRead: 64,
+	}
+	r, err := HeadFromBuffered(reader, nil, "HEAD")
+	require.NoError(t, err)
+	assert.True(t, r.BodyRead())
+	assert.Equal(t, int64(5), r.ContentLength)
+
+	r, err = HeadFromBuffered(reader, r.Buffered(), "GET")
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Empty(t, r.Buffered())
+
+	// Test: Streaming a chunked body leaves the bytes after it buffered
+	reader = &testutil.ChunkReader{
+		Data:            "4\r\nwiki\r\n5\r\npedia\r\n0\r\n\r\nnext",
+		NumBytesPerRead: 64,
+	}
+	r, err = HeadFromBuffered(reader, []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"), "GET")
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "wikipedia", string(body))
+	assert.Equal(t, "next", string(r.Buffered()))
+}
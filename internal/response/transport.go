@@ -0,0 +1,37 @@
+package response
+
+import "tcpToHttp/internal/headers"
+
+// Transport carries a response over another protocol like HTTP/2, the
+// writer hands over the head, body and trailers instead of framing them
+type Transport interface {
+	// WriteHead sends a final or an interim (1xx) head
+	WriteHead(statusCode StatusCode, h *headers.Headers) error
+	WriteData(p []byte) (int, error)
+	// WriteTrailers sends the trailer section, h may be empty
+	WriteTrailers(h *headers.Headers) error
+}
+
+// connectionHeaders only make sense for a single HTTP/1.x connection and
+// must not be forwarded over another protocol (RFC 9113 §8.2.2)
+var connectionHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade",
+}
+
+// NewTransportWriter returns a Writer for a response carried by t, handlers
+// use it the same way as a Writer on an HTTP/1.x connection
+func NewTransportWriter(t Transport) *Writer {
+	w := NewWriter(transportBody{t})
+	w.transport = t
+	w.keepAlive = true
+	return w
+}
+
+// transportBody makes the body writes of the Writer go to the transport
+type transportBody struct {
+	t Transport
+}
+
+func (b transportBody) Write(p []byte) (int, error) {
+	return b.t.WriteData(p)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"tcpToHttp/internal/negotiate"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+)
+
+// compressedTypes are content types that don't shrink when compressed again
+var compressedTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed",
+	"text/event-stream",
+}
+
+func skipCompression(contentType string) bool {
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		return false
+	}
+	for _, t := range compressedTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+var encoders = map[string]func(w io.Writer) io.WriteCloser{
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	// the deflate content coding is the zlib format (RFC 9110 §8.4.1.2)
+	"deflate": func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	},
+}
+
+// Compress negotiates gzip or deflate from Accept-Encoding and compresses
+// response bodies of at least minSize bytes
+func Compress(minSize int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			// without Accept-Encoding any coding is allowed, but clients that
+			// don't send it rarely expect compressed bodies
+			name := ""
+			if _, ok := req.Headers.Get("Accept-Encoding"); ok {
+				name, _ = negotiate.Encoding(req.Headers, "gzip", "deflate", "identity")
+			}
+			if name == "identity" {
+				name = ""
+			}
+			w.SetEncoding(&response.Encoding{
+				Name:    name,
+				New:     encoders[name],
+				MinSize: minSize,
+				Skip:    skipCompression,
+			})
+			return next(w, req)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"time"
+)
+
+// Conditional gives buffered 200 responses to GET and HEAD a strong ETag
+// from the body unless the handler set one, and answers If-None-Match,
+// If-Modified-Since and friends with 304 or 412. Unsafe methods have to
+// check preconditions themselves before acting, with response.CheckPreconditions
+func Conditional() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			hErr := next(w, req)
+			method := req.RequestLine.Method
+			if hErr != nil || (method != "GET" && method != "HEAD") {
+				return hErr
+			}
+
+			status, body, ok := w.Buffered()
+			if !ok || status != response.StatusOK {
+				return nil
+			}
+
+			etag, ok := w.Header().Get("ETag")
+			if !ok {
+				etag = response.StrongETag(body)
+				w.Header().Set("ETag", etag, true)
+			}
+			modtime := time.Time{}
+			if lastModified, ok := w.Header().Get("Last-Modified"); ok {
+				modtime, _ = response.ParseHTTPDate(lastModified)
+			}
+
+			if _, err := response.CheckPreconditions(w, req, etag, modtime); err != nil {
+				return &HandlerError{
+					StatusCode: response.StatusServerError,
+					Message:    err.Error(),
+				}
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+)
+
+type fileServer struct {
+	root    fs.FS
+	listing bool
+}
+
+type FileServerOption func(fs *fileServer)
+
+// WithDirectoryListing renders an HTML listing for directories without
+// an index.html instead of answering 404
+func WithDirectoryListing() FileServerOption {
+	return func(fs *fileServer) {
+		fs.listing = true
+	}
+}
+
+// FileServer serves the files of root, it works with os.DirFS and
+// embed.FS alike. Mounted on a catch-all route like "/static/*filepath"
+// the file path is taken from the catch-all, otherwise from the request path
+func FileServer(root fs.FS, opts ...FileServerOption) HandlerFunc {
+	fsrv := &fileServer{
+		root: root,
+	}
+	for _, opt := range opts {
+		opt(fsrv)
+	}
+	return fsrv.serve
+}
+
+func (fsrv *fileServer) serve(w *response.Writer, req *request.Request) *HandlerError {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		return &HandlerError{
+			StatusCode: response.StatusMethodNotAllowed,
+			Message:    "method not allowed\n",
+		}
+	}
+
+	urlPath := req.RequestLine.Path
+	name, isCatchAll := req.Params["*"]
+	if !isCatchAll {
+		name = strings.TrimPrefix(urlPath, "/")
+	}
+
+	// the request path is already free of dot segments, percent-encoded
+	// ones are caught by fs.ValidPath after decoding
+	name, err := request.PathUnescape(name)
+	if err != nil {
+		return &HandlerError{
+			StatusCode: response.StatusBadReq,
+			Message:    "invalid path\n",
+		}
+	}
+	name = strings.TrimSuffix(name, "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || strings.ContainsAny(name, "\\\x00") {
+		return &HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "not found\n",
+		}
+	}
+
+	info, err := fs.Stat(fsrv.root, name)
+	if err != nil {
+		return &HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "not found\n",
+		}
+	}
+
+	if info.IsDir() {
+		// relative links in the directory only work with a trailing slash
+		if !strings.HasSuffix(urlPath, "/") {
+			location := urlPath + "/"
+			if req.RequestLine.RawQuery != "" {
+				location += "?" + req.RequestLine.RawQuery
+			}
+			w.Redirect(response.StatusMovedPermanently, location)
+			return nil
+		}
+
+		index := path.Join(name, "index.html")
+		if indexInfo, err := fs.Stat(fsrv.root, index); err == nil && !indexInfo.IsDir() {
+			return fsrv.serveFile(w, req, index, indexInfo)
+		}
+		if fsrv.listing {
+			return fsrv.serveListing(w, name)
+		}
+		return &HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "not found\n",
+		}
+	}
+	return fsrv.serveFile(w, req, name, info)
+}
+
+func (fsrv *fileServer) serveFile(w *response.Writer, req *request.Request, name string, info fs.FileInfo) *HandlerError {
+	file, err := fsrv.root.Open(name)
+	if err != nil {
+		return &HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "not found\n",
+		}
+	}
+	defer file.Close()
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		// not every fs.FS has seekable files, those are read into memory
+		b, err := io.ReadAll(file)
+		if err != nil {
+			return &HandlerError{
+				StatusCode: response.StatusServerError,
+				Message:    "error reading file\n",
+			}
+		}
+		content = bytes.NewReader(b)
+	}
+
+	// modification time and size are a cheap validator for files
+	if _, ok := w.Header().Get("ETag"); !ok {
+		w.Header().Set("ETag", fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size()), true)
+	}
+
+	if err := response.ServeContent(w, req, info.Name(), info.ModTime(), content); err != nil {
+		return &HandlerError{
+			StatusCode: response.StatusServerError,
+			Message:    "error reading file\n",
+		}
+	}
+	return nil
+}
+
+func (fsrv *fileServer) serveListing(w *response.Writer, name string) *HandlerError {
+	entries, err := fs.ReadDir(fsrv.root, name)
+	if err != nil {
+		return &HandlerError{
+			StatusCode: response.StatusServerError,
+			Message:    "error reading directory\n",
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<html>\n<body>\n<ul>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(escapePath(entryName)), html.EscapeString(entryName))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	w.HTML(response.StatusOK, b.String())
+	return nil
+}
+
+// escapePath percent-encodes the bytes of a file name that can't appear
+// in a relative URL as is
+func escapePath(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		// a colon is escaped too since "a:b" would be read as a scheme
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("-._~/!$&'()*+,;=@", ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileServer(t *testing.T) {
+	root := fstest.MapFS{
+		"index.html":          {Data: []byte("<html>home</html>"), ModTime: time.Now()},
+		"css/site.css":        {Data: []byte("body{}"), ModTime: time.Now()},
+		"docs/a file.txt":     {Data: []byte("spaced"), ModTime: time.Now()},
+		"docs/readme":         {Data: []byte("<!doctype html><p>hi</p>"), ModTime: time.Now()},
+		"private/secret.key":  {Data: []byte("nope"), ModTime: time.Now()},
+		"docs/nested/file.md": {Data: []byte("# md"), ModTime: time.Now()},
+	}
+	handler := FileServer(root, WithDirectoryListing())
+
+	serve := func(target, catchAll string) (string, *HandlerError) {
+		req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		req.Params = map[string]string{"filepath": catchAll, "*": catchAll}
+		buf := &bytes.Buffer{}
+		w := response.NewWriter(buf)
+		hErr := handler(w, req)
+		require.NoError(t, w.Finish())
+		return buf.String(), hErr
+	}
+
+	// Test: File with content type from the extension
+	out, hErr := serve("/static/css/site.css", "css/site.css")
+	require.Nil(t, hErr)
+	assert.Contains(t, out, "content-type: text/css; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nbody{}"))
+
+	// Test: Content type sniffed when there is no extension
+	out, hErr = serve("/static/docs/readme", "docs/readme")
+	require.Nil(t, hErr)
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+
+	// Test: Percent-encoded names
+	out, hErr = serve("/static/docs/a%20file.txt", "docs/a%20file.txt")
+	require.Nil(t, hErr)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nspaced"))
+
+	// Test: index.html for the root directory
+	out, hErr = serve("/static/", "")
+	require.Nil(t, hErr)
+	assert.True(t, strings.HasSuffix(out, "<html>home</html>"))
+
+	// Test: Directory without a trailing slash is redirected
+	out, hErr = serve("/static/docs?x=1", "docs")
+	require.Nil(t, hErr)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /static/docs/?x=1\r\n")
+
+	// Test: Directory listing
+	out, hErr = serve("/static/docs/", "docs/")
+	require.Nil(t, hErr)
+	assert.Contains(t, out, "<a href=\"a%20file.txt\">a file.txt</a>")
+	assert.Contains(t, out, "<a href=\"nested/\">nested/</a>")
+
+	// Test: Encoded traversal is rejected
+	_, hErr = serve("/static/%2e%2e/private/secret.key", "%2e%2e/private/secret.key")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusNotFound, hErr.StatusCode)
+
+	// Test: Missing file
+	_, hErr = serve("/static/missing.txt", "missing.txt")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusNotFound, hErr.StatusCode)
+}
+
+func TestRouterMatch(t *testing.T) {
+	router := newRouter("")
+	router.GET("/static/*filepath", func(w *response.Writer, req *request.Request) *HandlerError { return nil })
+	router.GET("/static/:name/info", func(w *response.Writer, req *request.Request) *HandlerError { return nil })
+	router.POST("/users/:id", func(w *response.Writer, req *request.Request) *HandlerError { return nil })
+
+	// Test: Catch-all
+	handler, params, _ := router.lookup("GET", "/static/css/site.css")
+	require.NotNil(t, handler)
+	assert.Equal(t, "css/site.css", params["filepath"])
+
+	// Test: Params win over a catch-all
+	_, params, _ = router.lookup("GET", "/static/logo/info")
+	assert.Equal(t, "logo", params["name"])
+	assert.NotContains(t, params, "filepath")
+
+	// Test: HEAD falls back to GET
+	handler, _, _ = router.lookup("HEAD", "/static/a")
+	assert.NotNil(t, handler)
+
+	// Test: Other methods list what is allowed
+	handler, _, allowed := router.lookup("GET", "/users/7")
+	assert.Nil(t, handler)
+	assert.Equal(t, []string{"POST"}, allowed)
+}
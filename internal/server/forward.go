@@ -0,0 +1,208 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"tcpToHttp/internal/client"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"time"
+)
+
+// viaPseudonym names this proxy in the Via field of forwarded messages
+const viaPseudonym = "tcpToHttp"
+
+// ForwardProxy serves clients configured to use this server as their
+// HTTP proxy, plain http requests come with an absolute-form target and
+// anything else is tunnelled with CONNECT
+type ForwardProxy struct {
+	client *client.Client
+	// allowed are host:port patterns, see AllowDestinations
+	allowed     []string
+	dialTimeout time.Duration
+}
+
+type ForwardProxyOption func(p *ForwardProxy)
+
+// AllowDestinations adds destinations the proxy may reach, a pattern is a
+// host like "example.com" or "*.example.com" with an optional port, "*"
+// or no port allows any port. Without patterns every destination is refused
+func AllowDestinations(patterns ...string) ForwardProxyOption {
+	return func(p *ForwardProxy) {
+		for _, pattern := range patterns {
+			p.allowed = append(p.allowed, strings.ToLower(pattern))
+		}
+	}
+}
+
+// WithForwardClient sets the client used for plain http requests
+func WithForwardClient(c *client.Client) ForwardProxyOption {
+	return func(p *ForwardProxy) {
+		p.client = c
+	}
+}
+
+func NewForwardProxy(opts ...ForwardProxyOption) *ForwardProxy {
+	p := &ForwardProxy{
+		dialTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.client == nil {
+		p.client = client.New()
+	}
+	return p
+}
+
+// SetForwardProxy makes the server act as a forward proxy, requests with
+// an absolute-form target for another host and CONNECT requests bypass
+// the routes and go through the middleware to the proxy instead
+func (s *Server) SetForwardProxy(p *ForwardProxy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forwardProxy = p
+}
+
+// allows checks a destination against the allowlist
+func (p *ForwardProxy) allows(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range p.allowed {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			patternHost, patternPort = strings.Trim(pattern, "[]"), ""
+		}
+		if patternPort != "" && patternPort != "*" && patternPort != port {
+			continue
+		}
+		if patternHost == host || matchHost(patternHost, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve tunnels CONNECT requests and forwards absolute-form ones
+func (p *ForwardProxy) Serve(w *response.Writer, req *request.Request) *HandlerError {
+	if req.RequestLine.Method == "CONNECT" {
+		return p.tunnel(w, req)
+	}
+
+	rl := req.RequestLine
+	if rl.TargetForm != request.FormAbsolute {
+		return &HandlerError{
+			StatusCode: response.StatusBadReq,
+			Message:    "proxy requests need an absolute-form target\n",
+		}
+	}
+	if rl.Scheme != "http" {
+		return &HandlerError{
+			StatusCode: response.StatusNotImplemented,
+			Message:    "https is only proxied through CONNECT\n",
+		}
+	}
+
+	addr := rl.Authority
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "80")
+	}
+	if !p.allows(addr) {
+		return &HandlerError{
+			StatusCode: response.StatusForbidden,
+			Message:    "destination not allowed\n",
+		}
+	}
+
+	h := req.Headers.Clone()
+	removeHopHeaders(h)
+	h.Delete("Expect")
+	// the target authority replaces whatever Host the client sent
+	h.Set("Host", rl.Authority, true)
+	addVia(h, rl.HttpVersion, viaPseudonym)
+
+	target := rl.Path
+	if rl.RawQuery != "" {
+		target += "?" + rl.RawQuery
+	}
+	outReq := &client.Request{
+		Method:  rl.Method,
+		Addr:    addr,
+		Target:  target,
+		Headers: h,
+	}
+	setBody(outReq, req)
+
+	res, err := p.client.Do(outReq)
+	if err != nil {
+		log.Printf("error forwarding to %s: %v", addr, err)
+		return upstreamError(err)
+	}
+	defer res.Body.Close()
+	return copyResponse(w, res, viaPseudonym)
+}
+
+// tunnel dials the CONNECT target, answers 200 and splices the bytes in
+// both directions until both sides are done (RFC 9110 §9.3.6)
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) *HandlerError {
+	addr := req.RequestLine.Authority
+	if !p.allows(addr) {
+		return &HandlerError{
+			StatusCode: response.StatusForbidden,
+			Message:    "destination not allowed\n",
+		}
+	}
+
+	upstream, err := net.DialTimeout("tcp", addr, p.dialTimeout)
+	if err != nil {
+		log.Printf("error connecting to %s: %v", addr, err)
+		return upstreamError(err)
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		return &HandlerError{
+			StatusCode: response.StatusNotImplemented,
+			Message:    "tunnel not supported on this connection\n",
+		}
+	}
+	defer conn.Close()
+	defer upstream.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return nil
+	}
+	// the client may have sent the start of the tunnelled stream already
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			return nil
+		}
+	}
+	splice(conn, upstream)
+	return nil
+}
+
+// splice copies between a and b, when one side stops sending the other
+// learns it through a half-close so the rest can still flow back
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	<-done
+	<-done
+}
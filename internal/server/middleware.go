@@ -0,0 +1,22 @@
+package server
+
+// Middleware wraps a handler, it can act before and after the handler or
+// answer the request itself without calling it
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middleware that runs for every routed request, the first one
+// added is the outermost
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+func (s *Server) wrap(handler HandlerFunc) HandlerFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	return handler
+}
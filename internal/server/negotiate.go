@@ -0,0 +1,26 @@
+package server
+
+import (
+	"strings"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+)
+
+// Negotiate picks the media type of the response from Accept, when none of
+// the offers is acceptable it returns a 406 HandlerError listing them.
+// Handlers should send Vary: Accept with the chosen representation
+func Negotiate(req *request.Request, offers ...string) (string, *HandlerError) {
+	mediaType, ok := req.Negotiate(offers...)
+	if ok {
+		return mediaType, nil
+	}
+
+	vary := headers.NewHeaders()
+	vary.Set("Vary", "Accept", true)
+	return "", &HandlerError{
+		StatusCode: response.StatusNotAcceptable,
+		Message:    "not acceptable, available: " + strings.Join(offers, ", ") + "\n",
+		Headers:    vary,
+	}
+}
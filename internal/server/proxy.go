@@ -0,0 +1,346 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tcpToHttp/internal/client"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"time"
+)
+
+var ERROR_NO_UPSTREAM = fmt.Errorf("no upstream.")
+
+// hopHeaders only apply to a single connection and are never forwarded
+// (RFC 9110 §7.6.1), fields named in Connection are dropped as well
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type upstream struct {
+	url     string
+	addr    string
+	host    string
+	base    string
+	healthy atomic.Bool
+}
+
+// ReverseProxy forwards requests to one or more upstreams, picked round
+// robin among the healthy ones
+type ReverseProxy struct {
+	upstreams []*upstream
+	next      atomic.Uint64
+	client    *client.Client
+
+	healthPath     string
+	healthInterval time.Duration
+	stop           chan struct{}
+	stopOnce       sync.Once
+}
+
+type ProxyOption func(p *ReverseProxy)
+
+// WithProxyClient sets the client used to reach the upstreams
+func WithProxyClient(c *client.Client) ProxyOption {
+	return func(p *ReverseProxy) {
+		p.client = c
+	}
+}
+
+// WithHealthCheck sends a GET for path to every upstream each interval, an
+// upstream that fails or answers 5xx gets no requests until it recovers
+func WithHealthCheck(path string, interval time.Duration) ProxyOption {
+	return func(p *ReverseProxy) {
+		p.healthPath = path
+		p.healthInterval = interval
+	}
+}
+
+// NewReverseProxy builds a proxy for http:// upstream URLs, a path in the
+// URL is prepended to the forwarded path. Serve is the handler, mounted on
+// a catch-all route like "/api/*path" only the catch-all is forwarded.
+// Mounted with HandleStream the body is forwarded as it arrives
+func NewReverseProxy(targets []string, opts ...ProxyOption) (*ReverseProxy, error) {
+	if len(targets) == 0 {
+		return nil, ERROR_NO_UPSTREAM
+	}
+
+	p := &ReverseProxy{
+		stop: make(chan struct{}),
+	}
+	for _, target := range targets {
+		req, err := client.NewRequest("GET", target, nil)
+		if err != nil {
+			return nil, err
+		}
+		host, _ := req.Headers.Get("Host")
+		target, _, _ := strings.Cut(req.Target, "?")
+		u := &upstream{
+			url:  "http://" + host + target,
+			addr: req.Addr,
+			host: host,
+			base: strings.TrimSuffix(target, "/"),
+		}
+		u.healthy.Store(true)
+		p.upstreams = append(p.upstreams, u)
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.client == nil {
+		p.client = client.New()
+	}
+
+	if p.healthPath != "" && p.healthInterval > 0 {
+		go p.checkHealth()
+	}
+	return p, nil
+}
+
+// Close stops the health checks and closes idle upstream connections
+func (p *ReverseProxy) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	p.client.CloseIdle()
+}
+
+func (p *ReverseProxy) checkHealth() {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		for _, u := range p.upstreams {
+			healthy := p.probe(u)
+			if u.healthy.Swap(healthy) != healthy {
+				log.Printf("upstream %s healthy: %t", u.url, healthy)
+			}
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ReverseProxy) probe(u *upstream) bool {
+	res, err := p.client.Get("http://" + u.host + u.base + p.healthPath)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res.StatusCode < response.StatusServerError
+}
+
+// pick returns the next healthy upstream
+func (p *ReverseProxy) pick() *upstream {
+	start := p.next.Add(1)
+	for i := range uint64(len(p.upstreams)) {
+		u := p.upstreams[(start+i)%uint64(len(p.upstreams))]
+		if u.healthy.Load() {
+			return u
+		}
+	}
+	return nil
+}
+
+// Serve forwards req to an upstream and streams the response back,
+// failing to reach the upstream answers 502 or 504 on a timeout
+func (p *ReverseProxy) Serve(w *response.Writer, req *request.Request) *HandlerError {
+	u := p.pick()
+	if u == nil {
+		return &HandlerError{
+			StatusCode: response.StatusBadGateway,
+			Message:    "no healthy upstream\n",
+		}
+	}
+
+	outReq := p.outgoing(u, req)
+	res, err := p.client.Do(outReq)
+	if err != nil {
+		log.Printf("error proxying to %s: %v", u.url, err)
+		return upstreamError(err)
+	}
+	defer res.Body.Close()
+
+	return copyResponse(w, res, req.Host())
+}
+
+// copyResponse streams an upstream response to w, a body without length
+// is re-framed with chunks which also carry the trailers of a chunked
+// upstream response
+func copyResponse(w *response.Writer, res *client.Response, receivedBy string) *HandlerError {
+	h := res.Headers.Clone()
+	removeHopHeaders(h)
+	addVia(h, res.Version, receivedBy)
+	_, hasLength := h.Get("Content-Length")
+	chunked := !hasLength && res.StatusCode.AllowsBody()
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked", true)
+	}
+
+	if err := w.WriteStatusLine(res.StatusCode); err != nil {
+		return nil
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return nil
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			var writeErr error
+			if chunked {
+				_, writeErr = w.WriteChunkedBody(buf[:n])
+			} else {
+				_, writeErr = w.WriteBody(buf[:n])
+			}
+			if writeErr != nil {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the head is already out, the server drops the connection
+			return upstreamError(err)
+		}
+	}
+
+	if !chunked {
+		return nil
+	}
+	w.WriteChunkedBodyDone()
+	trailers := headers.NewHeaders()
+	if res.Trailers != nil {
+		trailers = res.Trailers.Clone()
+		removeHopHeaders(trailers)
+	}
+	w.WriteTrailers(*trailers)
+	return nil
+}
+
+// outgoing builds the request sent to u
+func (p *ReverseProxy) outgoing(u *upstream, req *request.Request) *client.Request {
+	h := req.Headers.Clone()
+	host, hasHost := h.Get("Host")
+	if !hasHost {
+		host = req.Host()
+	}
+	wantsTrailers := h.HasToken("TE", "trailers")
+	removeHopHeaders(h)
+	// 100 Continue is handled between the client and this server
+	h.Delete("Expect")
+	if wantsTrailers {
+		h.Set("TE", "trailers", true)
+	}
+	h.Set("Host", u.host, true)
+	addVia(h, req.RequestLine.HttpVersion, req.Host())
+
+	clientIP := req.RemoteAddr
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = ip
+	}
+	if clientIP != "" {
+		h.Set("X-Forwarded-For", clientIP, false)
+	}
+	if _, ok := h.Get("X-Forwarded-Host"); !ok {
+		h.Set("X-Forwarded-Host", host, true)
+	}
+	if _, ok := h.Get("X-Forwarded-Proto"); !ok {
+		h.Set("X-Forwarded-Proto", "http", true)
+	}
+
+	path := req.RequestLine.Path
+	if rest, ok := req.Params["*"]; ok {
+		path = "/" + rest
+	}
+	target := u.base + path
+	if req.RequestLine.RawQuery != "" {
+		target += "?" + req.RequestLine.RawQuery
+	}
+
+	outReq := &client.Request{
+		Method:  req.RequestLine.Method,
+		Addr:    u.addr,
+		Target:  target,
+		Headers: h,
+	}
+	setBody(outReq, req)
+	return outReq
+}
+
+// setBody forwards the body of req, it is streamed when the server left
+// it on the connection and keeps the chunked framing if it had trailers
+func setBody(outReq *client.Request, req *request.Request) {
+	switch {
+	case req.BodyRead() && req.Trailers != nil:
+		outReq.Body = bytes.NewReader(req.Body)
+		outReq.ContentLength = -1
+		outReq.Trailers = req.Trailers.Clone()
+		removeHopHeaders(outReq.Trailers)
+	case req.BodyRead() && len(req.Body) > 0:
+		outReq.Body = bytes.NewReader(req.Body)
+		outReq.ContentLength = int64(len(req.Body))
+	case !req.BodyRead():
+		outReq.Body = req.BodyReader()
+		outReq.ContentLength = int64(req.Headers.GetInt("Content-Length", -1))
+	}
+}
+
+func removeHopHeaders(h *headers.Headers) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Delete(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Delete(name)
+	}
+}
+
+// addVia records the hop in the Via field (RFC 9110 §7.6.3)
+func addVia(h *headers.Headers, version, receivedBy string) {
+	if version == "" {
+		version = "1.1"
+	}
+	h.Set("Via", version+" "+receivedBy, false)
+}
+
+// upstreamError maps a failure to reach the upstream to 504 when it
+// timed out and 502 otherwise
+func upstreamError(err error) *HandlerError {
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return &HandlerError{
+			StatusCode: response.StatusGatewayTimeout,
+			Message:    "upstream timed out\n",
+		}
+	}
+	return &HandlerError{
+		StatusCode: response.StatusBadGateway,
+		Message:    "bad gateway\n",
+	}
+}
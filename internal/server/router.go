@@ -0,0 +1,203 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type routeKey struct {
+	method string
+	path   string
+}
+
+// Router is a route table, the server has a default one and one per
+// virtual host registered with Server.Host
+type Router struct {
+	name   string
+	routes map[routeKey]*route
+	mu     sync.RWMutex
+}
+
+type route struct {
+	handler HandlerFunc
+	// streamBody leaves the body unread for the handler, the server
+	// doesn't buffer it first
+	streamBody bool
+}
+
+func newRouter(name string) *Router {
+	return &Router{
+		name:   name,
+		routes: make(map[routeKey]*route),
+	}
+}
+
+// Handle registers a handler for any method, including extension methods
+// like PROPFIND or PURGE. Methods are case-sensitive
+func (r *Router) Handle(method, path string, handler HandlerFunc) {
+	r.registerRoute(method, path, &route{handler: handler})
+}
+
+// HandleStream registers a handler that reads the request body itself
+// through req.BodyReader, like a proxy forwarding it as it arrives. The
+// server only checks the body size and answers 100 Continue
+func (r *Router) HandleStream(method, path string, handler HandlerFunc) {
+	r.registerRoute(method, path, &route{handler: handler, streamBody: true})
+}
+
+func (r *Router) GET(path string, handler HandlerFunc) {
+	r.registerRoute("GET", path, &route{handler: handler})
+}
+
+func (r *Router) POST(path string, handler HandlerFunc) {
+	r.registerRoute("POST", path, &route{handler: handler})
+}
+
+func (r *Router) registerRoute(method, path string, rt *route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := routeKey{
+		method,
+		path,
+	}
+	r.routes[key] = rt
+	log.Printf("Registered %s %s%s", method, r.name, path)
+}
+
+// lookup returns the route and path params for the method and path, when
+// there is none allowed lists the methods the path is routed for. HEAD
+// requests fall back to the GET route
+func (r *Router) lookup(method, path string) (rt *route, params map[string]string, allowed []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rt, params, exists := r.find(method, path)
+	if !exists && method == "HEAD" {
+		rt, params, exists = r.find("GET", path)
+	}
+	if exists {
+		return rt, params, nil
+	}
+
+	for k := range r.routes {
+		if _, ok := r.matchPath(k.path, path); ok {
+			allowed = append(allowed, k.method)
+			if k.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+		}
+	}
+	slices.Sort(allowed)
+	return nil, nil, slices.Compact(allowed)
+}
+
+func (r *Router) find(method, path string) (*route, map[string]string, bool) {
+	key := routeKey{
+		method: method,
+		path:   path,
+	}
+	if rt, exists := r.routes[key]; exists {
+		return rt, nil, true
+	}
+	return r.findPatternMatch(method, path)
+}
+
+// hasMethod reports whether any route is registered for the method
+func (r *Router) hasMethod(method string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for k := range r.routes {
+		if k.method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// findPatternMatch picks the most specific pattern matching the path,
+// patterns with more static segments win and catch-alls come last
+func (r *Router) findPatternMatch(method, path string) (*route, map[string]string, bool) {
+	var best *route
+	var bestParams map[string]string
+	bestScore := 0
+	for k, rt := range r.routes {
+		if k.method != method || !strings.ContainsAny(k.path, ":*") {
+			continue
+		}
+
+		params, ok := r.matchPath(k.path, path)
+		if !ok {
+			continue
+		}
+		score := patternScore(k.path)
+		if best == nil || score > bestScore {
+			best, bestParams, bestScore = rt, params, score
+		}
+	}
+	return best, bestParams, best != nil
+}
+
+func patternScore(pattern string) int {
+	score := 0
+	for _, part := range strings.Split(pattern, "/") {
+		switch {
+		case strings.HasPrefix(part, "*"):
+			score -= 1000
+		case strings.HasPrefix(part, ":"):
+		default:
+			score++
+		}
+	}
+	return score
+}
+
+// matchPath matches a path against a pattern where ":name" matches one
+// segment and a trailing "*name" matches the rest of the path
+func (r *Router) matchPath(pattern, path string) (map[string]string, bool) {
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(path, "/")
+	params := map[string]string{}
+
+	for i := range patternParts {
+		if name, ok := strings.CutPrefix(patternParts[i], "*"); ok && i == len(patternParts)-1 {
+			if i > len(pathParts) {
+				return nil, false
+			}
+			rest := ""
+			if i < len(pathParts) {
+				rest = strings.Join(pathParts[i:], "/")
+			}
+			params[name] = rest
+			params["*"] = rest
+			return params, true
+		}
+
+		if i >= len(pathParts) {
+			return nil, false
+		}
+		if name, ok := strings.CutPrefix(patternParts[i], ":"); ok {
+			params[name] = pathParts[i]
+			continue
+		}
+		if patternParts[i] != pathParts[i] {
+			return nil, false
+		}
+	}
+
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+	return params, true
+}
+
+// matchHost reports whether host matches the pattern, a pattern like
+// "*.example.local" matches any subdomain of example.local
+func matchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return pattern == host
+}
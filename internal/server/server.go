@@ -1,15 +1,24 @@
 package server
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/http2"
+	"tcpToHttp/internal/message"
 	"tcpToHttp/internal/request"
 	"tcpToHttp/internal/response"
+	"time"
 )
 
 type HandlerFunc func(w *response.Writer, req *request.Request) *HandlerError
@@ -17,54 +26,192 @@ type HandlerFunc func(w *response.Writer, req *request.Request) *HandlerError
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
+	// Headers are extra fields sent with the error, like Allow for a 405
+	Headers *headers.Headers
+	// JSON sends the message as {"error": ..., "status": ...} for API routes
+	JSON bool
+}
+
+// NewJSONError returns a HandlerError written as a JSON object
+func NewJSONError(statusCode response.StatusCode, message string) *HandlerError {
+	return &HandlerError{
+		StatusCode: statusCode,
+		Message:    message,
+		JSON:       true,
+	}
 }
 
 func (hErr HandlerError) Write(w *response.Writer) {
 	w.WriteStatusLine(hErr.StatusCode)
 	messageBytes := []byte(hErr.Message)
+	contentType := "text/plain"
+	if hErr.JSON {
+		messageBytes, _ = json.Marshal(map[string]any{
+			"error":  strings.TrimSpace(hErr.Message),
+			"status": hErr.StatusCode,
+		})
+		messageBytes = append(messageBytes, '\n')
+		contentType = "application/json"
+	}
 	headers := response.GetDefaultHeaders(len(messageBytes))
+	headers.Set("Content-Type", contentType, true)
+	if hErr.Headers != nil {
+		hErr.Headers.ForEach(func(k, v string) {
+			headers.Set(k, v, true)
+		})
+	}
 	w.WriteHeaders(*headers)
 	w.WriteBody(messageBytes)
 }
 
-type routeKey struct {
-	method string
-	path   string
-}
+// ContinueMode decides when a request with Expect: 100-continue gets its
+// interim 100 Continue response
+type ContinueMode int
+
+const (
+	// ContinueOnRead sends 100 Continue when the handler reads the body,
+	// so handlers and middleware can reject the request before the upload
+	ContinueOnRead ContinueMode = iota
+	// ContinueImmediate sends 100 Continue as soon as the head is parsed
+	ContinueImmediate
+)
 
 type Server struct {
-	closed   atomic.Bool
-	listener net.Listener
-	port     uint16
-	routes   map[routeKey]HandlerFunc
-	mu       sync.RWMutex
+	*Router
+	closed       atomic.Bool
+	listener     net.Listener
+	port         uint16
+	hosts        map[string]*Router
+	middleware   []Middleware
+	continueMode ContinueMode
+	maxBodySize  int
+	dates        *response.DateCache
+	serverName   string
+	forwardProxy *ForwardProxy
+	mu           sync.RWMutex
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	// conns are the open connections the server still manages, hijacked
+	// ones are removed
+	conns  map[net.Conn]struct{}
+	connMu sync.Mutex
 }
 
 func New(port uint16) *Server {
 	return &Server{
+		Router: newRouter(""),
 		port:   port,
-		routes: make(map[routeKey]HandlerFunc),
+		hosts:  make(map[string]*Router),
+		dates:  response.NewDateCache(time.Now),
+		conns:  make(map[net.Conn]struct{}),
 	}
 }
 
-func (s *Server) GET(path string, handler HandlerFunc) {
-	s.registerRoute("GET", path, handler)
+// SetClock replaces the clock used for the Date header
+func (s *Server) SetClock(clock response.Clock) {
+	s.dates = response.NewDateCache(clock)
+}
+
+// SetServerName sets the Server header sent with every response
+func (s *Server) SetServerName(name string) {
+	s.serverName = name
+}
+
+func (s *Server) newWriter(conn net.Conn) *response.Writer {
+	resWriter := response.NewWriter(conn)
+	resWriter.SetDate(s.dates)
+	resWriter.SetServerName(s.serverName)
+	return resWriter
+}
+
+func (s *Server) SetContinueMode(mode ContinueMode) {
+	s.continueMode = mode
+}
+
+// SetMaxBodySize rejects requests with a larger Content-Length with 413
+// before any of the body is read, 0 means no limit
+func (s *Server) SetMaxBodySize(size int) {
+	s.maxBodySize = size
+}
+
+// SetReadTimeout limits how long reading a request may take, 0 means no limit
+func (s *Server) SetReadTimeout(timeout time.Duration) {
+	s.readTimeout = timeout
+}
+
+// SetWriteTimeout limits how long writing a response may take, 0 means no limit
+func (s *Server) SetWriteTimeout(timeout time.Duration) {
+	s.writeTimeout = timeout
 }
 
-func (s *Server) POST(path string, handler HandlerFunc) {
-	s.registerRoute("POST", path, handler)
+// SetIdleTimeout limits how long a keep-alive connection waits for the
+// next request, 0 falls back to the read timeout
+func (s *Server) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout = timeout
 }
 
-func (s *Server) registerRoute(method, path string, handler HandlerFunc) {
+// Host returns the route table of a virtual host, the pattern is either
+// an exact host name or a wildcard like "*.example.local". Requests for
+// hosts without a table fall back to the routes registered on the server
+func (s *Server) Host(pattern string) *Router {
+	pattern = strings.ToLower(pattern)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	key := routeKey{
-		method,
-		path,
+
+	router, ok := s.hosts[pattern]
+	if !ok {
+		router = newRouter(pattern)
+		s.hosts[pattern] = router
+	}
+	return router
+}
+
+// standardMethods are the methods defined in RFC 9110 §9 and RFC 5789,
+// any other method is only known once a route is registered for it
+var standardMethods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH",
+}
+
+// knownMethod decides between 501 and 405/404 for an unrouted request
+func (s *Server) knownMethod(method string) bool {
+	if slices.Contains(standardMethods, method) || s.hasMethod(method) {
+		return true
 	}
-	s.routes[key] = handler
-	log.Printf("Registered %s %s", method, path)
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, router := range s.hosts {
+		if router.hasMethod(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// routerFor picks the route table for a host, exact names win over
+// wildcards and longer wildcards win over shorter ones
+func (s *Server) routerFor(host string) *Router {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if router, ok := s.hosts[host]; ok {
+		return router
+	}
+
+	var best *Router
+	bestLen := 0
+	for pattern, router := range s.hosts {
+		if len(pattern) > bestLen && matchHost(pattern, host) {
+			best = router
+			bestLen = len(pattern)
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return s.Router
 }
 
 func (s *Server) Serve() error {
@@ -79,12 +226,43 @@ func (s *Server) Serve() error {
 	return nil
 }
 
+// Close stops accepting connections and closes the open ones, hijacked
+// connections belong to their handlers and are left alone
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	var err error
 	if s.listener != nil {
-		return s.listener.Close()
+		err = s.listener.Close()
+	}
+
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
+
+func (s *Server) track(conn net.Conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	delete(s.conns, conn)
+}
+
+// setDeadline sets a read or write deadline, a zero timeout clears it
+func setDeadline(set func(time.Time) error, timeout time.Duration) {
+	if timeout > 0 {
+		set(time.Now().Add(timeout))
+	} else {
+		set(time.Time{})
 	}
-	return nil
 }
 
 func (s *Server) listen() {
@@ -97,109 +275,335 @@ func (s *Server) listen() {
 			log.Printf("error accepting connection: %v", err)
 			continue
 		}
+		if s.closed.Load() {
+			connection.Close()
+			return
+		}
 		go s.handleConn(connection)
 	}
 }
 
 func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
-	resWriter := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		hErr := &HandlerError{
-			StatusCode: response.StatusBadReq,
-			Message:    err.Error(),
+	s.track(conn)
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			s.untrack(conn)
+			conn.Close()
 		}
-		hErr.Write(resWriter)
-		return
-	}
+	}()
 
-	s.mu.RLock()
-	key := routeKey{
-		method: req.RequestLine.Method,
-		path:   req.RequestLine.RequestTarget,
+	// a client with prior knowledge starts with the HTTP/2 preface
+	setDeadline(conn.SetReadDeadline, s.readTimeout)
+	buffered, isHTTP2, err := sniffPreface(conn)
+	if err != nil {
+		return
 	}
-	handler, exists := s.routes[key]
-
-	if !exists {
-		handler, exists = s.findPatternMatch(req.RequestLine.Method, req.RequestLine.RequestTarget)
+	if isHTTP2 {
+		s.serveHTTP2(conn, buffered, nil)
+		return
 	}
-	s.mu.RUnlock()
 
-	if !exists {
-		s.mu.RLock()
-		pathExists := false
+	for served := 0; ; served++ {
+		if served > 0 && s.idleTimeout > 0 {
+			setDeadline(conn.SetReadDeadline, s.idleTimeout)
+		} else {
+			setDeadline(conn.SetReadDeadline, s.readTimeout)
+		}
 
-		for k := range s.routes {
-			if k.path == req.RequestLine.RequestTarget {
-				pathExists = true
-				break
+		// pipelined requests may already be buffered, they are served one
+		// at a time so the responses go out in request order
+		req, err := request.HeadFromBuffered(conn, buffered)
+		if err != nil {
+			// the client closed the connection between requests or took
+			// too long to send one
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+				return
 			}
-		}
-		s.mu.RUnlock()
 
-		if pathExists {
+			resWriter := s.newWriter(conn)
 			hErr := &HandlerError{
-				StatusCode: response.StatusMethodNotAllowed,
+				StatusCode: response.StatusBadReq,
 				Message:    err.Error(),
 			}
+			if errors.Is(err, request.ERROR_UNSUPPORTED_HTTP_VERSION) {
+				hErr.StatusCode = response.StatusHTTPVersionNotSupported
+			}
+			if errors.Is(err, request.ERROR_UNSUPPORTED_TRANSFER_ENCODING) {
+				hErr.StatusCode = response.StatusNotImplemented
+			}
+			if errors.Is(err, request.ERROR_REQUEST_LINE_TOO_LONG) {
+				hErr.StatusCode = response.StatusURITooLong
+			}
+			if errors.Is(err, request.ERROR_HEAD_TOO_LARGE) {
+				hErr.StatusCode = response.StatusRequestHeaderFieldsTooLarge
+			}
 			hErr.Write(resWriter)
-		} else {
-			hErr := &HandlerError{
-				StatusCode: response.StatusNotFound,
+			return
+		}
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if http2.UpgradeRequested(req) {
+			s.serveHTTP2(conn, nil, req)
+			return
+		}
+
+		resWriter := s.newWriter(conn)
+		resWriter.SetVersion(req.RequestLine.HttpVersion)
+		resWriter.SetKeepAlive(req.KeepAlive())
+		resWriter.SetHeadRequest(req.RequestLine.Method == "HEAD")
+		resWriter.SetHijacker(func() (net.Conn, []byte, error) {
+			// the handler owns the connection from now on
+			hijacked = true
+			s.untrack(conn)
+			conn.SetDeadline(time.Time{})
+			return conn, req.Buffered(), nil
+		})
+		setDeadline(conn.SetReadDeadline, s.readTimeout)
+		setDeadline(conn.SetWriteDeadline, s.writeTimeout)
+		s.serve(resWriter, req)
+		if hijacked {
+			return
+		}
+
+		// an unread body is still on the connection so it can't be reused
+		if !resWriter.KeepAlive() || !req.BodyRead() {
+			return
+		}
+		buffered = req.Buffered()
+	}
+}
+
+// sniffPreface reads just enough of a new connection to tell the HTTP/2
+// preface from an HTTP/1.x request, the bytes read are returned either way
+func sniffPreface(conn net.Conn) ([]byte, bool, error) {
+	buf := make([]byte, len(http2.Preface))
+	read := 0
+	for read < len(buf) {
+		n, err := conn.Read(buf[read:])
+		read += n
+		if !strings.HasPrefix(http2.Preface, string(buf[:read])) {
+			return buf[:read], false, nil
+		}
+		if err != nil {
+			return buf[:read], false, err
+		}
+	}
+	return buf, true, nil
+}
+
+// serveHTTP2 serves an h2c connection, req is the HTTP/1.1 request that
+// asked for the upgrade or nil for a client with prior knowledge. Every
+// stream goes through the same routing and middleware as HTTP/1.x requests
+func (s *Server) serveHTTP2(conn net.Conn, buffered []byte, req *request.Request) {
+	// deadlines per request don't fit multiplexed streams, the timeouts
+	// apply to each stream instead
+	conn.SetDeadline(time.Time{})
+	idleTimeout := s.idleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = s.readTimeout
+	}
+	opts := &http2.Options{
+		MaxHeaderListSize: message.MaxHeadSize,
+		IdleTimeout:       idleTimeout,
+		ReadTimeout:       s.readTimeout,
+		WriteTimeout:      s.writeTimeout,
+	}
+	handler := func(resWriter *response.Writer, req *request.Request) {
+		resWriter.SetDate(s.dates)
+		resWriter.SetServerName(s.serverName)
+		req.RemoteAddr = conn.RemoteAddr().String()
+		s.serve(resWriter, req)
+	}
+
+	var err error
+	if req != nil {
+		err = http2.ServeUpgrade(conn, req, handler, opts)
+	} else {
+		err = http2.ServeConn(conn, buffered, handler, opts)
+	}
+	if err != nil {
+		log.Printf("error serving http2: %v", err)
+	}
+}
+
+// prepareBody reads the body before the handler runs unless the client
+// waits for 100 Continue, the body is multipart or the route streams it,
+// in that case it may be read by the handler
+func (s *Server) prepareBody(resWriter *response.Writer, req *request.Request, streamBody bool) *HandlerError {
+	if expect, ok := req.Headers.Get("Expect"); ok && !strings.EqualFold(expect, "100-continue") {
+		return &HandlerError{
+			StatusCode: response.StatusExpectationFailed,
+			Message:    "unsupported expectation\n",
+		}
+	}
+
+	if s.maxBodySize > 0 && req.Headers.GetInt("Content-Length", 0) > s.maxBodySize {
+		return &HandlerError{
+			StatusCode: response.StatusContentTooLarge,
+			Message:    "content too large\n",
+		}
+	}
+	// a chunked body has no length up front so it is cut off while reading
+	req.SetMaxBodySize(s.maxBodySize)
+
+	if req.ExpectsContinue() && s.continueMode == ContinueOnRead {
+		req.OnReadBody(func() error {
+			if resWriter.Started() {
+				return nil
+			}
+			return resWriter.WriteContinue()
+		})
+		return nil
+	}
+
+	if req.ExpectsContinue() {
+		if err := resWriter.WriteContinue(); err != nil {
+			return &HandlerError{
+				StatusCode: response.StatusServerError,
 				Message:    err.Error(),
 			}
-			hErr.Write(resWriter)
 		}
-		return
 	}
+	// multipart bodies are streamed by the handler through MultipartReader
+	if streamBody || req.IsMultipart() {
+		return nil
+	}
+	if _, err := req.ReadBody(); err != nil {
+		if errors.Is(err, request.ERROR_BODY_TOO_LARGE) {
+			return &HandlerError{
+				StatusCode: response.StatusContentTooLarge,
+				Message:    "content too large\n",
+			}
+		}
+		return &HandlerError{
+			StatusCode: response.StatusBadReq,
+			Message:    err.Error(),
+		}
+	}
+	return nil
+}
 
-	buf := bytes.NewBuffer([]byte{})
-	hErr := handler(resWriter, req)
+func (s *Server) serve(resWriter *response.Writer, req *request.Request) {
+	handler, streamBody, hErr := s.route(req)
+	if bErr := s.prepareBody(resWriter, req, streamBody); bErr != nil {
+		resWriter.SetKeepAlive(false)
+		bErr.Write(resWriter)
+		return
+	}
 	if hErr != nil {
 		hErr.Write(resWriter)
 		return
 	}
 
-	b := buf.Bytes()
-	headers := response.GetDefaultHeaders(len(b))
-	resWriter.WriteStatusLine(response.StatusOK)
-	resWriter.WriteHeaders(*headers)
-	conn.Write(b)
-	return
+	hErr = s.wrap(handler)(resWriter, req)
+	if hErr != nil {
+		if resWriter.Hijacked() {
+			log.Printf("handler error after hijacking: %d %s", hErr.StatusCode, hErr.Message)
+			return
+		}
+		if resWriter.Started() {
+			// the response is already on the wire and may be incomplete,
+			// closing the connection is the only way to signal the failure
+			log.Printf("handler error after response started: %d %s", hErr.StatusCode, hErr.Message)
+			resWriter.SetKeepAlive(false)
+			return
+		}
+		hErr.Write(resWriter)
+	}
+	// an error body may be encoded by the middleware too, finishing ends
+	// its chunked body before the next response on the connection
+	if err := resWriter.Finish(); err != nil {
+		log.Printf("error finishing response: %v", err)
+		resWriter.SetKeepAlive(false)
+	}
 }
 
-func (s *Server) findPatternMatch(method, path string) (HandlerFunc, bool) {
-	for k, h := range s.routes {
-		if k.method != method {
-			continue
+// ownAuthority reports whether an absolute-form target names this server,
+// a virtual host or a local address on the listening port. A server has to
+// accept those for its own resources (RFC 9112 §3.2.2)
+func (s *Server) ownAuthority(req *request.Request) bool {
+	host := req.Host()
+	s.mu.RLock()
+	for pattern := range s.hosts {
+		if matchHost(pattern, host) {
+			s.mu.RUnlock()
+			return true
 		}
+	}
+	s.mu.RUnlock()
 
-		if strings.Contains(k.path, ":") {
-			if s.matchPath(k.path, path) {
-				return h, true
-			}
-		}
+	port := "80"
+	if _, p, err := net.SplitHostPort(req.RequestLine.Authority); err == nil && p != "" {
+		port = p
 	}
-	return nil, false
+	if s.listener == nil || port != strconv.Itoa(s.listener.Addr().(*net.TCPAddr).Port) {
+		return false
+	}
+	return isLocalHost(strings.Trim(host, "[]"))
 }
 
-func (s *Server) matchPath(pattern, path string) bool {
-	patternParts := strings.Split(pattern, "/")
-	pathParts := strings.Split(path, "/")
-
-	if len(patternParts) != len(pathParts) {
+// isLocalHost reports whether host is localhost, the machine's name or one
+// of its addresses
+func isLocalHost(host string) bool {
+	if hostname, err := os.Hostname(); host == "localhost" || err == nil && strings.EqualFold(host, hostname) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
 		return false
 	}
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
 
-	for i := range patternParts {
-		if strings.HasPrefix(patternParts[i], ":") {
-			continue
+// route picks the handler for req and sets its path params, a forward
+// proxy takes CONNECT and absolute-form requests for other hosts before
+// the routes.
+// streamBody reports whether the handler reads the body itself
+func (s *Server) route(req *request.Request) (handler HandlerFunc, streamBody bool, hErr *HandlerError) {
+	method := req.RequestLine.Method
+	s.mu.RLock()
+	forwardProxy := s.forwardProxy
+	s.mu.RUnlock()
+	forwarded := method == "CONNECT" || req.RequestLine.TargetForm == request.FormAbsolute && !s.ownAuthority(req)
+	if forwardProxy != nil && forwarded {
+		return forwardProxy.Serve, true, nil
+	}
+
+	router := s.routerFor(req.Host())
+	rt, params, allowed := router.lookup(method, req.RequestLine.Path)
+	switch {
+	case rt != nil:
+		req.Params = params
+		return rt.handler, rt.streamBody, nil
+	case !s.knownMethod(method):
+		return nil, false, &HandlerError{
+			StatusCode: response.StatusNotImplemented,
+			Message:    "not implemented\n",
 		}
-		if patternParts[i] != pathParts[i] {
-			return false
+	case len(allowed) > 0:
+		allow := headers.NewHeaders()
+		allow.Set("Allow", strings.Join(allowed, ", "), true)
+		return nil, false, &HandlerError{
+			StatusCode: response.StatusMethodNotAllowed,
+			Message:    "method not allowed\n",
+			Headers:    allow,
+		}
+	default:
+		return nil, false, &HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "not found\n",
 		}
 	}
-
-	return true
 }
@@ -0,0 +1,927 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"tcpToHttp/internal/client"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/http2"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	newRequest := func(accept string) *request.Request {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept: " + accept + "\r\n\r\n"))
+		require.NoError(t, err)
+		return req
+	}
+
+	// Test: Acceptable offer
+	mediaType, hErr := Negotiate(newRequest("application/json, text/html;q=0.9"), "text/html", "application/json")
+	require.Nil(t, hErr)
+	assert.Equal(t, "application/json", mediaType)
+
+	// Test: 406 listing the offers
+	_, hErr = Negotiate(newRequest("image/png"), "text/html", "application/json")
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusNotAcceptable, hErr.StatusCode)
+	assert.Contains(t, hErr.Message, "text/html, application/json")
+	vary, _ := hErr.Headers.Get("Vary")
+	assert.Equal(t, "Accept", vary)
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		match   bool
+	}{
+		{"example.local", "example.local", true},
+		{"example.local", "www.example.local", false},
+		{"*.example.local", "api.example.local", true},
+		{"*.example.local", "a.b.example.local", true},
+		{"*.example.local", "example.local", false},
+		{"*.example.local", ".example.local", false},
+		{"*.example.local", "badexample.local", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, matchHost(tt.pattern, tt.host), tt.pattern+" "+tt.host)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	s := New(0)
+	noop := func(w *response.Writer, req *request.Request) *HandlerError { return nil }
+	s.Host("example.local").GET("/", noop)
+	s.Host("*.example.local").GET("/", noop)
+	s.Host("*.api.example.local").GET("/", noop)
+
+	tests := []struct {
+		host   string
+		router string
+	}{
+		{"example.local", "example.local"},
+		{"Example.Local:8080", "example.local"},
+		{"example.local.", "example.local"},
+		{"www.example.local", "*.example.local"},
+		{"www.example.local:443", "*.example.local"},
+		{"v1.api.example.local", "*.api.example.local"},
+		{"other.local", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		req := must(request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + tt.host + "\r\n\r\n")))
+		assert.Equal(t, tt.router, s.routerFor(req.Host()).name, tt.host)
+	}
+
+	// Test: The authority of an absolute-form target wins over Host
+	req := must(request.RequestFromReader(strings.NewReader("GET http://www.example.local/ HTTP/1.1\r\nHost: other.local\r\n\r\n")))
+	assert.Equal(t, "*.example.local", s.routerFor(req.Host()).name)
+}
+
+func startServer(t *testing.T, setup func(s *Server)) (*Server, string) {
+	s := New(0)
+	setup(s)
+	require.NoError(t, s.Serve())
+	t.Cleanup(func() { s.Close() })
+	return s, s.listener.Addr().String()
+}
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	_, addr := startServer(t, func(s *Server) {
+		s.SetReadTimeout(50 * time.Millisecond)
+		s.GET("/tunnel", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Write([]byte("dropped"))
+			conn, buffered, err := w.Hijack()
+			if !assert.NoError(t, err) {
+				return nil
+			}
+			_, err = w.Write([]byte("late"))
+			assert.Equal(t, response.ERROR_HIJACKED, err)
+
+			// speak a custom protocol, the bytes sent along with the
+			// request head are handed over too
+			conn.Write([]byte("HELLO " + string(buffered) + "\n"))
+			hijacked <- conn
+			return nil
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\nearly"))
+	require.NoError(t, err)
+
+	// Test: The handler writes straight to the connection
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO early\n", line)
+
+	// Test: The server neither times out nor closes a hijacked connection
+	serverSide := <-hijacked
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(serverSide, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	serverSide.Close()
+}
+
+func TestHijackMiddleware(t *testing.T) {
+	buffered := make(chan bool, 1)
+	_, addr := startServer(t, func(s *Server) {
+		s.Use(Compress(1), Conditional(), func(next HandlerFunc) HandlerFunc {
+			return func(w *response.Writer, req *request.Request) *HandlerError {
+				hErr := next(w, req)
+				_, _, ok := w.Buffered()
+				buffered <- ok
+				return hErr
+			}
+		})
+		s.GET("/tunnel", func(w *response.Writer, req *request.Request) *HandlerError {
+			conn, _, err := w.Hijack()
+			if !assert.NoError(t, err) {
+				return nil
+			}
+			conn.Write([]byte("raw\n"))
+			conn.Close()
+			return nil
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nIf-None-Match: *\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Middleware leaves a hijacked connection alone
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "raw\n", string(rest))
+	assert.False(t, <-buffered)
+}
+
+func TestTimeoutsAndClose(t *testing.T) {
+	s, addr := startServer(t, func(s *Server) {
+		s.SetReadTimeout(50 * time.Millisecond)
+		s.SetIdleTimeout(time.Minute)
+		s.GET("/", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, "ok")
+			return nil
+		})
+	})
+
+	// Test: A client that never sends a request is disconnected
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	conn.Close()
+
+	// Test: Idle keep-alive connections are closed by Close
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, s.Close())
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(br)
+	require.NoError(t, err)
+}
+
+func TestPipelining(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.GET("/slow", func(w *response.Writer, req *request.Request) *HandlerError {
+			time.Sleep(20 * time.Millisecond)
+			w.Text(response.StatusOK, "slow")
+			return nil
+		})
+		s.GET("/fast", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, "fast")
+			return nil
+		})
+		s.POST("/echo", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, string(req.Body))
+			return nil
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: Requests sent back to back are answered in order
+	_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nbody" +
+		"GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /missing HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	bodies := []string{}
+	for _, res := range strings.Split(string(out), "HTTP/1.1 ")[1:] {
+		_, body, _ := strings.Cut(res, "\r\n\r\n")
+		bodies = append(bodies, res[:3]+" "+strings.TrimSpace(body))
+	}
+	assert.Equal(t, []string{"200 slow", "200 body", "200 fast", "404 not found"}, bodies)
+}
+
+func TestHTTP2(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.SetServerName("test")
+		s.GET("/hello/:name", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Header().Set("Connection", "keep-alive", true)
+			w.Text(response.StatusOK, "hello "+req.Param("name"))
+			return nil
+		})
+		s.POST("/echo", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, string(req.Body))
+			return nil
+		})
+	})
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}, Timeout: 5 * time.Second}
+	defer client.CloseIdleConnections()
+
+	// Test: Handlers run unchanged over HTTP/2 with prior knowledge
+	res, err := client.Get("http://" + addr + "/hello/h2")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, "hello h2", string(body))
+	assert.Equal(t, "test", res.Header.Get("Server"))
+	assert.Empty(t, res.Header.Get("Connection"))
+
+	// Test: Concurrent requests are multiplexed with their bodies
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload := strings.Repeat(string(rune('a'+i)), 100000)
+			res, err := client.Post("http://"+addr+"/echo", "text/plain", strings.NewReader(payload))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, payload, string(body))
+		}()
+	}
+	wg.Wait()
+
+	// Test: Unknown routes get the usual errors
+	res, err = client.Get("http://" + addr + "/missing")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestHTTP2Upgrade(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.GET("/", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, "upgraded")
+			return nil
+		})
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Test: Upgrade: h2c switches protocols and answers on stream 1
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQCAAAAAAIAAAAA\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	conn.Write([]byte(http2.Preface))
+	conn.Write(http2.AppendFrame(nil, http2.FrameSettings, 0, 0, nil))
+	decoder := http2.NewDecoder(4096)
+	fields := map[string]string{}
+	body := ""
+	for {
+		f, err := http2.ReadFrame(reader, 1<<14)
+		require.NoError(t, err)
+		if f.StreamID != 1 {
+			continue
+		}
+		if f.Type == http2.FrameHeaders {
+			decoded, err := decoder.Decode(f.Payload)
+			require.NoError(t, err)
+			for _, field := range decoded {
+				fields[field.Name] = field.Value
+			}
+		}
+		if f.Type == http2.FrameData {
+			body += string(f.Payload)
+		}
+		if f.Has(http2.FlagEndStream) {
+			break
+		}
+	}
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "upgraded", body)
+
+	// Test: A request with a body isn't upgraded
+	conn2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	conn2.SetDeadline(time.Now().Add(2 * time.Second))
+	conn2.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\nhi"))
+	status, err = bufio.NewReader(conn2).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+}
+
+func TestInvalidContentLength(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		ok := func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, req.RequestLine.Path)
+			return nil
+		}
+		s.POST("/", ok)
+		s.GET("/admin", ok)
+	})
+
+	// Test: A body hidden behind a bad Content-Length isn't served as a request
+	for _, value := range []string{"abc", "-41", "41, 0"} {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: " + value + "\r\n\r\n" +
+			"GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		rest, err := io.ReadAll(conn)
+		conn.Close()
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(rest), "HTTP/1.1 400 "), value)
+		assert.Equal(t, 1, strings.Count(string(rest), "HTTP/1.1 "), value)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		ok := func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, req.RequestLine.Method)
+			return nil
+		}
+		s.GET("/items", ok)
+		s.POST("/items", ok)
+		s.Handle("PURGE", "/cache", ok)
+	})
+
+	send := func(raw string) (*response.Response, string) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(raw))
+		res, err := response.ResponseFromReader(conn, "GET")
+		require.NoError(t, err)
+		return res, string(res.Body)
+	}
+
+	// Test: A known method on a path routed for other methods
+	res, _ := send("DELETE /items HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusLine.StatusCode)
+	allow, ok := res.Headers.Get("Allow")
+	require.True(t, ok)
+	assert.ElementsMatch(t, []string{"GET", "HEAD", "POST"}, strings.Split(allow, ", "))
+
+	// Test: An extension method registered on another path is known
+	res, _ = send("PURGE /items HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusMethodNotAllowed, res.StatusLine.StatusCode)
+
+	// Test: An unknown method is not implemented
+	res, _ = send("BREW /items HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.StatusNotImplemented, res.StatusLine.StatusCode)
+	_, ok = res.Headers.Get("Allow")
+	assert.False(t, ok)
+
+	// Test: A routed extension method
+	_, body := send("PURGE /cache HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "PURGE", body)
+}
+
+func TestCompressConditional(t *testing.T) {
+	body := strings.Repeat("compress me ", 50)
+	_, addr := startServer(t, func(s *Server) {
+		s.Use(Compress(100), Conditional())
+		s.GET("/", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, body)
+			return nil
+		})
+	})
+
+	send := func(raw string) *response.Response {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(raw))
+		res, err := response.ResponseFromReader(conn, "GET")
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: The identity body keeps the strong ETag
+	res := send("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	identityTag, ok := res.Headers.Get("ETag")
+	require.True(t, ok)
+	assert.False(t, strings.HasPrefix(identityTag, "W/"))
+	assert.Equal(t, body, string(res.Body))
+
+	// Test: The gzip body gets a weak ETag
+	res = send("GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	encoding, _ := res.Headers.Get("Content-Encoding")
+	assert.Equal(t, "gzip", encoding)
+	gzipTag, _ := res.Headers.Get("ETag")
+	assert.Equal(t, "W/"+identityTag, gzipTag)
+
+	// Test: The weak ETag still revalidates
+	res = send("GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nIf-None-Match: " + gzipTag + "\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, response.StatusNotModified, res.StatusLine.StatusCode)
+}
+
+func TestCompressError(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.Use(Compress(100))
+		s.GET("/fail", func(w *response.Writer, req *request.Request) *HandlerError {
+			return &HandlerError{
+				StatusCode: response.StatusServerError,
+				Message:    strings.Repeat("went wrong ", 20),
+			}
+		})
+		s.GET("/ok", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, "ok")
+			return nil
+		})
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Test: An encoded error body ends before the next response
+	conn.Write([]byte("GET /fail HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+	res, err := response.ResponseFromReader(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusServerError, res.StatusLine.StatusCode)
+	encoding, _ := res.Headers.Get("Content-Encoding")
+	assert.Equal(t, "gzip", encoding)
+	zr, err := gzip.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("went wrong ", 20), string(body))
+
+	conn.Write([]byte("GET /ok HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err = response.ResponseFromReader(conn, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(res.Body))
+}
+
+func TestChunkedRequest(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.SetMaxBodySize(10)
+		s.POST("/echo", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, string(req.Body))
+			return nil
+		})
+	})
+
+	send := func(raw string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(raw))
+		out, _ := io.ReadAll(conn)
+		return string(out)
+	}
+
+	// Test: A chunked body is decoded before the handler runs
+	out := send("POST /echo HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n" +
+		"3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nabcde"), out)
+
+	// Test: A chunked body over the limit
+	out = send("POST /echo HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"b\r\nhello world\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 "), out)
+
+	// Test: Transfer codings other than chunked aren't implemented
+	out = send("POST /echo HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 "), out)
+}
+
+// rawUpstream answers every request on its connections with the response
+// reply returns, written as is
+func rawUpstream(t *testing.T, reply func(req *request.Request) string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var buffered []byte
+				for {
+					req, err := request.HeadFromBuffered(conn, buffered)
+					if err != nil {
+						return
+					}
+					if _, err := req.ReadBody(); err != nil {
+						return
+					}
+					buffered = req.Buffered()
+					if _, err := conn.Write([]byte(reply(req))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestReverseProxy(t *testing.T) {
+	seen := make(chan *request.Request, 10)
+	upstreamAddr := rawUpstream(t, func(req *request.Request) string {
+		seen <- req
+		switch req.RequestLine.Path {
+		case "/base/slow":
+			time.Sleep(300 * time.Millisecond)
+			return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+		case "/base/stream":
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+				"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: 42\r\n\r\n"
+		}
+		return "HTTP/1.1 201 Created\r\nConnection: X-Secret\r\nX-Secret: 1\r\nKeep-Alive: timeout=5\r\nContent-Length: " +
+			strconv.Itoa(len(req.Body)) + "\r\n\r\n" + string(req.Body)
+	})
+
+	upstreamClient := client.New()
+	upstreamClient.ResponseHeaderTimeout = 100 * time.Millisecond
+	proxy, err := NewReverseProxy([]string{"http://" + upstreamAddr + "/base"}, WithProxyClient(upstreamClient))
+	require.NoError(t, err)
+	t.Cleanup(proxy.Close)
+	_, addr := startServer(t, func(s *Server) {
+		s.GET("/api/*path", proxy.Serve)
+		s.POST("/api/*path", proxy.Serve)
+	})
+	_, port, _ := net.SplitHostPort(addr)
+	addr = "127.0.0.1:" + port
+	c := client.New()
+	t.Cleanup(c.CloseIdle)
+
+	// Test: Hop-by-hop headers are stripped both ways, Via and X-Forwarded-* added
+	req, err := client.NewRequest("POST", "http://"+addr+"/api/items?x=1", strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Headers.Set("Connection", "X-Hop", true)
+	req.Headers.Set("X-Hop", "1", true)
+	req.Headers.Set("X-Forwarded-For", "10.0.0.1", true)
+	res, err := c.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCreated, res.StatusCode)
+	assert.Equal(t, "payload", string(body))
+	_, ok := res.Headers.Get("X-Secret")
+	assert.False(t, ok)
+	_, ok = res.Headers.Get("Keep-Alive")
+	assert.False(t, ok)
+	via, _ := res.Headers.Get("Via")
+	assert.Equal(t, "1.1 127.0.0.1", via)
+
+	got := <-seen
+	assert.Equal(t, "/base/items?x=1", got.RequestLine.RequestTarget)
+	host, _ := got.Headers.Get("Host")
+	assert.Equal(t, upstreamAddr, host)
+	_, ok = got.Headers.Get("X-Hop")
+	assert.False(t, ok)
+	forwardedFor, _ := got.Headers.Get("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1, 127.0.0.1", forwardedFor)
+	forwardedHost, _ := got.Headers.Get("X-Forwarded-Host")
+	assert.Equal(t, addr, forwardedHost)
+	via, _ = got.Headers.Get("Via")
+	assert.Equal(t, "1.1 127.0.0.1", via)
+
+	// Test: A chunked response is streamed with its trailers
+	res, err = c.Get("http://" + addr + "/api/stream")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	sum, _ := res.Trailers.Get("X-Sum")
+	assert.Equal(t, "42", sum)
+	<-seen
+
+	// Test: A chunked request is forwarded with its trailers
+	req, err = client.NewRequest("POST", "http://"+addr+"/api/upload", io.MultiReader(strings.NewReader("abc"), strings.NewReader("de")))
+	require.NoError(t, err)
+	req.Trailers = headers.NewHeaders()
+	req.Trailers.Set("X-Checksum", "abcde", true)
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	io.ReadAll(res.Body)
+	got = <-seen
+	assert.Equal(t, "abcde", string(got.Body))
+	checksum, _ := got.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abcde", checksum)
+
+	// Test: An upstream that doesn't answer in time is a 504
+	res, err = c.Get("http://" + addr + "/api/slow")
+	require.NoError(t, err)
+	io.ReadAll(res.Body)
+	assert.Equal(t, response.StatusGatewayTimeout, res.StatusCode)
+	<-seen
+
+	// Test: An upstream that can't be reached is a 502
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downAddr := ln.Addr().String()
+	ln.Close()
+	down, err := NewReverseProxy([]string{"http://" + downAddr})
+	require.NoError(t, err)
+	w := response.NewWriter(io.Discard)
+	hErr := down.Serve(w, must(request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))))
+	require.NotNil(t, hErr)
+	assert.Equal(t, response.StatusBadGateway, hErr.StatusCode)
+}
+
+func TestReverseProxyStreamBody(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	heads := make(chan *request.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := request.HeadFromBuffered(conn, nil)
+		if err != nil {
+			return
+		}
+		heads <- req
+		body, err := req.ReadBody()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)))
+	}()
+
+	proxy, err := NewReverseProxy([]string{"http://" + ln.Addr().String()})
+	require.NoError(t, err)
+	t.Cleanup(proxy.Close)
+	_, addr := startServer(t, func(s *Server) {
+		s.HandleStream("POST", "/api/*path", proxy.Serve)
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Test: The upstream gets the request before the whole body arrived
+	conn.Write([]byte("POST /api/upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	select {
+	case got := <-heads:
+		assert.Equal(t, "/upload", got.RequestLine.Path)
+	case <-time.After(time.Second):
+		t.Fatal("the body was buffered before forwarding")
+	}
+	conn.Write([]byte("world"))
+	res, err := response.ResponseFromReader(conn, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "helloworld", string(res.Body))
+}
+
+func TestReverseProxyBalancing(t *testing.T) {
+	var mu sync.Mutex
+	healthy := map[string]bool{"a": true, "b": true}
+	backend := func(name string) string {
+		return rawUpstream(t, func(req *request.Request) string {
+			if req.RequestLine.Path == "/health" {
+				mu.Lock()
+				defer mu.Unlock()
+				if !healthy[name] {
+					return "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n"
+				}
+				return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+			}
+			return "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n" + name
+		})
+	}
+	proxy, err := NewReverseProxy([]string{"http://" + backend("a"), "http://" + backend("b")},
+		WithHealthCheck("/health", 20*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(proxy.Close)
+
+	serve := func() string {
+		buf := &strings.Builder{}
+		w := response.NewWriter(buf)
+		hErr := proxy.Serve(w, must(request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))))
+		if hErr != nil {
+			return strconv.Itoa(int(hErr.StatusCode))
+		}
+		out := buf.String()
+		return out[len(out)-1:]
+	}
+
+	// Test: Requests alternate between the upstreams
+	first := serve()
+	assert.NotEqual(t, first, serve())
+	assert.Equal(t, first, serve())
+
+	// Test: An unhealthy upstream is skipped until it recovers
+	mu.Lock()
+	healthy["a"] = false
+	mu.Unlock()
+	require.Eventually(t, func() bool { return serve() == "b" && serve() == "b" }, time.Second, 10*time.Millisecond)
+
+	// Test: No healthy upstream at all is a 502
+	mu.Lock()
+	healthy["b"] = false
+	mu.Unlock()
+	require.Eventually(t, func() bool { return serve() == "502" }, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	healthy["a"] = true
+	mu.Unlock()
+	require.Eventually(t, func() bool { return serve() == "a" }, time.Second, 10*time.Millisecond)
+}
+
+func must(req *request.Request, err error) *request.Request {
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
+
+func TestForwardProxy(t *testing.T) {
+	seen := make(chan *request.Request, 10)
+	upstreamAddr := rawUpstream(t, func(req *request.Request) string {
+		seen <- req
+		return "HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\nupstream"
+	})
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	echoAddr := echo.Addr().String()
+
+	_, addr := startServer(t, func(s *Server) {
+		s.SetForwardProxy(NewForwardProxy(AllowDestinations(upstreamAddr, echoAddr)))
+		s.GET("/", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, "local")
+			return nil
+		})
+		s.Host("app.local").GET("/", func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Text(response.StatusOK, "app")
+			return nil
+		})
+	})
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		return conn
+	}
+	var buffered []byte
+	readResponse := func(conn net.Conn, method string) *response.Response {
+		res, err := response.HeadFromBuffered(conn, buffered, method)
+		require.NoError(t, err)
+		_, err = res.ReadBody()
+		require.NoError(t, err)
+		buffered = res.Buffered()
+		return res
+	}
+
+	// Test: An absolute-form request is forwarded in origin-form
+	conn := dial()
+	conn.Write([]byte("GET http://" + upstreamAddr + "/path?q=1 HTTP/1.1\r\nHost: " + upstreamAddr +
+		"\r\nProxy-Connection: keep-alive\r\nProxy-Authorization: Basic Zm9vOmJhcg==\r\n\r\n"))
+	res := readResponse(conn, "GET")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "upstream", string(res.Body))
+	via, _ := res.Headers.Get("Via")
+	assert.Equal(t, "1.1 tcpToHttp", via)
+	got := <-seen
+	assert.Equal(t, "/path?q=1", got.RequestLine.RequestTarget)
+	host, _ := got.Headers.Get("Host")
+	assert.Equal(t, upstreamAddr, host)
+	via, _ = got.Headers.Get("Via")
+	assert.Equal(t, "1.1 tcpToHttp", via)
+	_, ok := got.Headers.Get("Proxy-Connection")
+	assert.False(t, ok)
+	_, ok = got.Headers.Get("Proxy-Authorization")
+	assert.False(t, ok)
+
+	// Test: Origin-form requests still go through the routes
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res = readResponse(conn, "GET")
+	assert.Equal(t, "local", string(res.Body))
+
+	// Test: Absolute-form naming the server itself is served locally
+	_, ownPort, _ := net.SplitHostPort(addr)
+	for _, authority := range []string{"localhost:" + ownPort, "127.0.0.1:" + ownPort} {
+		conn.Write([]byte("GET http://" + authority + "/ HTTP/1.1\r\nHost: " + authority + "\r\n\r\n"))
+		res = readResponse(conn, "GET")
+		assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode, authority)
+		assert.Equal(t, "local", string(res.Body), authority)
+	}
+	conn.Write([]byte("GET http://app.local/ HTTP/1.1\r\nHost: app.local\r\n\r\n"))
+	res = readResponse(conn, "GET")
+	assert.Equal(t, "app", string(res.Body))
+
+	// Test: Destinations outside the allowlist are refused
+	_, port, _ := net.SplitHostPort(upstreamAddr)
+	conn = dial()
+	buffered = nil
+	conn.Write([]byte("GET http://localhost:" + port + "/ HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res = readResponse(conn, "GET")
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+	conn.Write([]byte("CONNECT 127.0.0.1:1 HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n"))
+	res = readResponse(conn, "CONNECT")
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+
+	// Test: CONNECT splices both directions, bytes sent early included
+	conn = dial()
+	buffered = nil
+	conn.Write([]byte("CONNECT " + echoAddr + " HTTP/1.1\r\nHost: " + echoAddr + "\r\n\r\nearly"))
+	res = readResponse(conn, "CONNECT")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "Connection Established", res.StatusLine.ReasonPhrase)
+	conn.Write([]byte(" and late"))
+	conn.(*net.TCPConn).CloseWrite()
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "early and late", string(buffered)+string(out))
+}
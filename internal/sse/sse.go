@@ -0,0 +1,164 @@
+package sse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"time"
+)
+
+var ERROR_STREAM_CLOSED = fmt.Errorf("event stream closed.")
+var ERROR_INVALID_EVENT_FIELD = fmt.Errorf("event field contains a line break.")
+
+// Event is a single server-sent event, empty fields are left out
+type Event struct {
+	ID    string
+	Event string
+	// Data may span several lines, each becomes its own data field
+	Data  string
+	Retry time.Duration
+}
+
+// Stream writes events to a text/event-stream response. It owns the writer
+// until Close so the handler must not write to it directly meanwhile
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu        sync.Mutex
+	done      chan struct{}
+	err       error
+	heartbeat sync.WaitGroup
+}
+
+// New sends the head of the event stream, the body is chunked and every
+// event is flushed as soon as it's written
+func New(w *response.Writer, req *request.Request) (*Stream, error) {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream", true)
+	h.Set("Cache-Control", "no-cache", true)
+	w.WriteHeader(response.StatusOK)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	return &Stream{
+		w:           w,
+		lastEventID: lastEventID,
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// LastEventID returns the id the client saw last before reconnecting
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the stream is closed or a write failed, which
+// usually means the client went away
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the stream stopped
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.ID+e.Event, "\r\n") || strings.Contains(e.ID, "\x00") {
+		return ERROR_INVALID_EVENT_FIELD
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, clients ignore it but it keeps
+// intermediaries from timing out the connection
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+func (s *Stream) write(frame string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	_, err := s.w.Write([]byte(frame))
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.stop(err)
+	}
+	return err
+}
+
+// stop must be called with mu held
+func (s *Stream) stop(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+}
+
+// Heartbeat sends a comment every interval until the stream stops
+func (s *Stream) Heartbeat(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	s.heartbeat.Add(1)
+	go func() {
+		defer s.heartbeat.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.Comment("heartbeat")
+			}
+		}
+	}()
+}
+
+// Close stops the stream and waits for the heartbeat, the server ends the
+// chunked body once the handler returns
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	s.stop(ERROR_STREAM_CLOSED)
+	s.mu.Unlock()
+	s.heartbeat.Wait()
+	return nil
+}
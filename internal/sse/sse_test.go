@@ -0,0 +1,98 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer lets the test read what the heartbeat goroutine writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	err error
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newRequest(t *testing.T, extra string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestStream(t *testing.T) {
+	// Test: Head and event frames
+	buf := &syncBuffer{}
+	w := response.NewWriter(buf)
+	s, err := New(w, newRequest(t, "Last-Event-ID: 41\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "41", s.LastEventID())
+	assert.Contains(t, buf.String(), "content-type: text/event-stream\r\n")
+	assert.Contains(t, buf.String(), "cache-control: no-cache\r\n")
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+
+	require.NoError(t, s.Send(Event{ID: "42", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second}))
+	frame := "id: 42\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n"
+	assert.True(t, strings.HasSuffix(buf.String(), fmt.Sprintf("\r\n%x\r\n%s\r\n", len(frame), frame)))
+
+	require.NoError(t, s.Comment("hi"))
+	assert.True(t, strings.HasSuffix(buf.String(), ": hi\n\n\r\n"))
+
+	// Test: Fields with line breaks are rejected
+	assert.Equal(t, ERROR_INVALID_EVENT_FIELD, s.Send(Event{Event: "a\nb"}))
+
+	// Test: Close stops the stream and the server ends the body
+	require.NoError(t, s.Close())
+	assert.Equal(t, ERROR_STREAM_CLOSED, s.Send(Event{Data: "late"}))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+}
+
+func TestStreamHeartbeatAndDisconnect(t *testing.T) {
+	buf := &syncBuffer{}
+	s, err := New(response.NewWriter(buf), newRequest(t, ""))
+	require.NoError(t, err)
+
+	// Test: Heartbeat comments
+	s.Heartbeat(5 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(buf.String(), ": heartbeat\n\n")
+	}, time.Second, 5*time.Millisecond)
+
+	// Test: A failed write means the client is gone
+	gone := errors.New("broken pipe")
+	buf.mu.Lock()
+	buf.err = gone
+	buf.mu.Unlock()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not stopped after a failed write")
+	}
+	assert.Equal(t, gone, s.Err())
+	assert.Equal(t, gone, s.Send(Event{Data: "x"}))
+	require.NoError(t, s.Close())
+}
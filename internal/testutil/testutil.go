@@ -0,0 +1,21 @@
+package testutil
+
+import "io"
+
+// ChunkReader reads up to len(p) or NumBytesPerRead bytes of Data per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+type ChunkReader struct {
+	Data            string
+	NumBytesPerRead int
+	pos             int
+}
+
+func (cr *ChunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.Data) {
+		return 0, io.EOF
+	}
+	endIndex := min(cr.pos+cr.NumBytesPerRead, len(cr.Data))
+	n = copy(p, cr.Data[cr.pos:endIndex])
+	cr.pos += n
+	return n, nil
+}
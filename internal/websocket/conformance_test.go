@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The cases below follow the sections of the Autobahn test suite
+// (framing, pings, reserved bits, opcodes, fragmentation, UTF-8, close
+// handling and limits) against an echo server, run with
+// go test ./internal/websocket -run TestConformance -v
+
+// clientFrame builds a masked frame like a browser would send it
+func clientFrame(fin bool, rsv byte, opcode MessageType, payload []byte) []byte {
+	return rawFrame(fin, rsv, opcode, payload, true)
+}
+
+func rawFrame(fin bool, rsv byte, opcode MessageType, payload []byte, masked bool) []byte {
+	b0 := rsv<<4 | byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	b := []byte{b0}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if !masked {
+		return append(b, payload...)
+	}
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	b = append(b, mask[:]...)
+	start := len(b)
+	b = append(b, payload...)
+	maskBytes(mask, b[start:])
+	return b
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+type serverFrame struct {
+	opcode  MessageType
+	payload string
+}
+
+// parseServerFrames splits what the server sent into unmasked frames
+func parseServerFrames(t *testing.T, b []byte) []serverFrame {
+	frames := []serverFrame{}
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 2)
+		require.NotZero(t, b[0]&0x80, "server frames are never fragmented")
+		require.Zero(t, b[1]&0x80, "server frames are never masked")
+		opcode := MessageType(b[0] & 0x0f)
+		length := int(b[1] & 0x7f)
+		b = b[2:]
+		switch length {
+		case 126:
+			length = int(binary.BigEndian.Uint16(b))
+			b = b[2:]
+		case 127:
+			length = int(binary.BigEndian.Uint64(b))
+			b = b[8:]
+		}
+		frames = append(frames, serverFrame{opcode, string(b[:length])})
+		b = b[length:]
+	}
+	return frames
+}
+
+// runEcho sends input to an echo server and returns every frame it sent
+// back until it closed the connection
+func runEcho(t *testing.T, input []byte, maxMessageSize int) []serverFrame {
+	serverSide, clientSide := net.Pipe()
+	conn := newConn(serverSide, nil, true)
+	if maxMessageSize > 0 {
+		conn.maxMessageSize = maxMessageSize
+	}
+	go func() {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	}()
+	go func() {
+		clientSide.Write(input)
+	}()
+
+	output, _ := io.ReadAll(clientSide)
+	clientSide.Close()
+	return parseServerFrames(t, output)
+}
+
+func closeFrame(code int) serverFrame {
+	return serverFrame{CloseMessage, string(closePayload(code, ""))}
+}
+
+func TestConformance(t *testing.T) {
+	clientClose := clientFrame(true, 0, CloseMessage, closePayload(CloseNormal, ""))
+	frames := func(parts ...[]byte) []byte {
+		out := []byte{}
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+	text := func(s string) []byte { return clientFrame(true, 0, TextMessage, []byte(s)) }
+
+	tests := []struct {
+		name           string
+		input          []byte
+		maxMessageSize int
+		want           []serverFrame
+	}{
+		// 1 framing
+		{"1.1.1 empty text", frames(text(""), clientClose), 0, []serverFrame{{TextMessage, ""}, closeFrame(CloseNormal)}},
+		{"1.1.2 text 125", frames(text(strings.Repeat("*", 125)), clientClose), 0, []serverFrame{{TextMessage, strings.Repeat("*", 125)}, closeFrame(CloseNormal)}},
+		{"1.1.3 text 126", frames(text(strings.Repeat("*", 126)), clientClose), 0, []serverFrame{{TextMessage, strings.Repeat("*", 126)}, closeFrame(CloseNormal)}},
+		{"1.1.4 text 65535", frames(text(strings.Repeat("*", 65535)), clientClose), 0, []serverFrame{{TextMessage, strings.Repeat("*", 65535)}, closeFrame(CloseNormal)}},
+		{"1.1.5 text 65536", frames(text(strings.Repeat("*", 65536)), clientClose), 0, []serverFrame{{TextMessage, strings.Repeat("*", 65536)}, closeFrame(CloseNormal)}},
+		{"1.2.1 binary", frames(clientFrame(true, 0, BinaryMessage, []byte{0xfe, 0x00, 0xff}), clientClose), 0, []serverFrame{{BinaryMessage, "\xfe\x00\xff"}, closeFrame(CloseNormal)}},
+		{"1.3.1 unmasked frame", rawFrame(true, 0, TextMessage, []byte("hi"), false), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+
+		// 2 pings and pongs
+		{"2.1 ping", frames(clientFrame(true, 0, PingMessage, []byte("hello")), clientClose), 0, []serverFrame{{PongMessage, "hello"}, closeFrame(CloseNormal)}},
+		{"2.2 ping 125", frames(clientFrame(true, 0, PingMessage, []byte(strings.Repeat("p", 125))), clientClose), 0, []serverFrame{{PongMessage, strings.Repeat("p", 125)}, closeFrame(CloseNormal)}},
+		{"2.3 ping 126", clientFrame(true, 0, PingMessage, []byte(strings.Repeat("p", 126))), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"2.4 unsolicited pong", frames(clientFrame(true, 0, PongMessage, []byte("x")), text("after"), clientClose), 0, []serverFrame{{TextMessage, "after"}, closeFrame(CloseNormal)}},
+		{"2.5 fragmented ping", clientFrame(false, 0, PingMessage, []byte("x")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+
+		// 3 reserved bits
+		{"3.1 rsv1", clientFrame(true, 4, TextMessage, []byte("x")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"3.2 rsv3 after a valid message", frames(text("ok"), clientFrame(true, 1, TextMessage, []byte("x"))), 0, []serverFrame{{TextMessage, "ok"}, closeFrame(CloseProtocolError)}},
+
+		// 4 opcodes
+		{"4.1 reserved data opcode", clientFrame(true, 0, 3, nil), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"4.2 reserved control opcode", clientFrame(true, 0, 11, nil), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+
+		// 5 fragmentation
+		{"5.1 fragmented text", frames(clientFrame(false, 0, TextMessage, []byte("frag")), clientFrame(true, 0, continuationFrame, []byte("ment")), clientClose), 0, []serverFrame{{TextMessage, "fragment"}, closeFrame(CloseNormal)}},
+		{"5.2 ping between fragments", frames(clientFrame(false, 0, TextMessage, []byte("a")), clientFrame(true, 0, PingMessage, []byte("p")), clientFrame(true, 0, continuationFrame, []byte("b")), clientClose), 0, []serverFrame{{PongMessage, "p"}, {TextMessage, "ab"}, closeFrame(CloseNormal)}},
+		{"5.3 continuation without a message", clientFrame(true, 0, continuationFrame, []byte("x")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"5.4 new message before the last fragment", frames(clientFrame(false, 0, TextMessage, []byte("a")), text("b")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+
+		// 6 UTF-8
+		{"6.1 multibyte split across fragments", frames(clientFrame(false, 0, TextMessage, []byte("κό")[:3]), clientFrame(true, 0, continuationFrame, []byte("κό")[3:]), clientClose), 0, []serverFrame{{TextMessage, "κό"}, closeFrame(CloseNormal)}},
+		{"6.2 invalid utf-8", clientFrame(true, 0, TextMessage, []byte{0xce, 0xba, 0xe1, 0xbd}), 0, []serverFrame{closeFrame(CloseInvalidPayload)}},
+		{"6.3 invalid utf-8 in binary is fine", frames(clientFrame(true, 0, BinaryMessage, []byte{0xff}), clientClose), 0, []serverFrame{{BinaryMessage, "\xff"}, closeFrame(CloseNormal)}},
+
+		// 7 close handling
+		{"7.1 close without payload", clientFrame(true, 0, CloseMessage, nil), 0, []serverFrame{closeFrame(CloseNormal)}},
+		{"7.2 close code is echoed", clientFrame(true, 0, CloseMessage, closePayload(CloseGoingAway, "bye")), 0, []serverFrame{closeFrame(CloseGoingAway)}},
+		{"7.3 data after close is ignored", frames(clientClose, text("late")), 0, []serverFrame{closeFrame(CloseNormal)}},
+		{"7.4 one byte payload", clientFrame(true, 0, CloseMessage, []byte{0x03}), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"7.5 code 999", clientFrame(true, 0, CloseMessage, closePayload(999, "")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"7.6 code 1005", clientFrame(true, 0, CloseMessage, closePayload(CloseNoStatus, "")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"7.7 code 5000", clientFrame(true, 0, CloseMessage, closePayload(5000, "")), 0, []serverFrame{closeFrame(CloseProtocolError)}},
+		{"7.8 application code", clientFrame(true, 0, CloseMessage, closePayload(4000, "")), 0, []serverFrame{closeFrame(4000)}},
+		{"7.9 invalid utf-8 reason", clientFrame(true, 0, CloseMessage, closePayload(CloseNormal, "\xff")), 0, []serverFrame{closeFrame(CloseInvalidPayload)}},
+
+		// 9 limits
+		{"9.1 message too big", text(strings.Repeat("x", 2000)), 1024, []serverFrame{closeFrame(CloseMessageTooBig)}},
+		{"9.2 fragments too big", frames(clientFrame(false, 0, TextMessage, []byte(strings.Repeat("x", 600))), clientFrame(true, 0, continuationFrame, []byte(strings.Repeat("x", 600)))), 1024, []serverFrame{closeFrame(CloseMessageTooBig)}},
+		{"9.3 message at the limit", frames(text(strings.Repeat("x", 1024)), clientClose), 1024, []serverFrame{{TextMessage, strings.Repeat("x", 1024)}, closeFrame(CloseNormal)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runEcho(t, tt.input, tt.maxMessageSize)
+			if len(got) > 0 && len(tt.want) > 0 && got[len(got)-1].opcode == CloseMessage {
+				// the close reason is informative only, compare the code
+				last := &got[len(got)-1]
+				last.payload = last.payload[:2]
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var ERROR_CLOSE_SENT = fmt.Errorf("websocket close already sent.")
+var ERROR_INVALID_MESSAGE_TYPE = fmt.Errorf("invalid websocket message type.")
+var ERROR_INVALID_CLOSE_CODE = fmt.Errorf("invalid websocket close code.")
+var ERROR_CONTROL_TOO_LARGE = fmt.Errorf("websocket control frame payload too large.")
+
+type MessageType int
+
+// frame opcodes from RFC 6455 §5.2
+const (
+	continuationFrame MessageType = 0
+	TextMessage       MessageType = 1
+	BinaryMessage     MessageType = 2
+	CloseMessage      MessageType = 8
+	PingMessage       MessageType = 9
+	PongMessage       MessageType = 10
+)
+
+func (t MessageType) isControl() bool {
+	return t >= CloseMessage
+}
+
+// close status codes from RFC 6455 §7.4.1
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseMandatoryExt    = 1010
+	CloseInternalError   = 1011
+)
+
+const maxControlPayload = 125
+
+// closeTimeout is how long Close waits for the peer to answer
+const closeTimeout = 5 * time.Second
+
+// CloseError is returned by ReadMessage once the connection is closed,
+// Code is CloseNoStatus when the peer sent no code
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// validCloseCode reports whether a code may be sent in a close frame
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// Conn is a WebSocket connection. One goroutine may read and another write
+// at the same time, pings are answered while reading
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	server      bool
+	subprotocol string
+
+	maxMessageSize int
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	// PongHandler is called with the payload of every pong
+	PongHandler func(data []byte)
+}
+
+// newConn wraps a connection, buffered are bytes already read from it.
+// A server expects masked frames and sends unmasked ones, a client the
+// other way around
+func newConn(conn net.Conn, buffered []byte, server bool) *Conn {
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	return &Conn{
+		conn:           conn,
+		br:             bufio.NewReader(r),
+		server:         server,
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// Subprotocol returns the negotiated Sec-WebSocket-Protocol
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+type frame struct {
+	fin     bool
+	opcode  MessageType
+	payload []byte
+}
+
+// readFrame reads a single frame, limit caps the payload length
+func (c *Conn) readFrame(limit int) (*frame, *CloseError) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return nil, &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+	}
+
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		opcode: MessageType(head[0] & 0x0f),
+	}
+	// no extension is negotiated so the reserved bits must be 0
+	if head[0]&0x70 != 0 {
+		return nil, &CloseError{Code: CloseProtocolError, Reason: "reserved bits set"}
+	}
+	switch f.opcode {
+	case continuationFrame, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return nil, &CloseError{Code: CloseProtocolError, Reason: "unknown opcode"}
+	}
+
+	masked := head[1]&0x80 != 0
+	if masked != c.server {
+		return nil, &CloseError{Code: CloseProtocolError, Reason: "wrong masking"}
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return nil, &CloseError{Code: CloseProtocolError, Reason: "invalid payload length"}
+		}
+	}
+
+	if f.opcode.isControl() {
+		if !f.fin {
+			return nil, &CloseError{Code: CloseProtocolError, Reason: "fragmented control frame"}
+		}
+		if length > maxControlPayload {
+			return nil, &CloseError{Code: CloseProtocolError, Reason: "control frame too large"}
+		}
+	} else if length > uint64(limit) {
+		return nil, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return nil, &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, &CloseError{Code: CloseAbnormal, Reason: err.Error()}
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+// ReadMessage returns the next text or binary message, fragments are
+// joined and control frames are handled in between. Protocol violations
+// close the connection with the matching status and a *CloseError is
+// returned once the connection is closed
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	for {
+		f, closeErr := c.readFrame(c.maxMessageSize - len(message))
+		if closeErr != nil {
+			return 0, nil, c.fail(closeErr)
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, f.payload); err != nil && err != ERROR_CLOSE_SENT {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.PongHandler != nil {
+				c.PongHandler(f.payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.receiveClose(f.payload)
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Reason: "unexpected continuation frame"})
+			}
+		default:
+			if messageType != 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Reason: "expected continuation frame"})
+			}
+			messageType = f.opcode
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid utf-8"})
+		}
+		return messageType, message, nil
+	}
+}
+
+// fail closes the connection after a read error, a close frame is sent
+// unless the connection itself broke
+func (c *Conn) fail(closeErr *CloseError) error {
+	if closeErr.Code != CloseAbnormal {
+		c.writeClose(closeErr.Code, closeErr.Reason)
+	}
+	c.conn.Close()
+	return closeErr
+}
+
+// receiveClose answers a close frame with the same code and closes the
+// connection (RFC 6455 §5.5.1)
+func (c *Conn) receiveClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(&CloseError{Code: CloseProtocolError, Reason: "invalid close payload"})
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(&CloseError{Code: CloseProtocolError, Reason: "invalid close code"})
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid utf-8"})
+		}
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	c.writeClose(code, "")
+	c.conn.Close()
+	return closeErr
+}
+
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return ERROR_INVALID_MESSAGE_TYPE
+	}
+	return c.writeFrame(messageType, data)
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return ERROR_CONTROL_TOO_LARGE
+	}
+	return c.writeFrame(PingMessage, data)
+}
+
+// Close starts the closing handshake and closes the connection once the
+// peer answers or after a timeout. It must not run while another
+// goroutine is in ReadMessage, that one sees the reply instead
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.writeClose(code, reason); err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	for {
+		f, closeErr := c.readFrame(c.maxMessageSize)
+		if closeErr != nil || f.opcode == CloseMessage {
+			break
+		}
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	if !validCloseCode(code) {
+		return ERROR_INVALID_CLOSE_CODE
+	}
+	// a long reason is cut at a rune boundary so it stays valid UTF-8
+	if max := maxControlPayload - 2; len(reason) > max {
+		for max > 0 && !utf8.RuneStart(reason[max]) {
+			max--
+		}
+		reason = reason[:max]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(CloseMessage, payload)
+}
+
+func (c *Conn) writeFrame(opcode MessageType, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ERROR_CLOSE_SENT
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	b := []byte{0x80 | byte(opcode)}
+	maskBit := byte(0)
+	if !c.server {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	if c.server {
+		b = append(b, payload...)
+	} else {
+		var mask [4]byte
+		rand.Read(mask[:])
+		b = append(b, mask[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		maskBytes(mask, b[start:])
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"tcpToHttp/internal/headers"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+)
+
+var ERROR_NOT_WEBSOCKET = fmt.Errorf("not a websocket upgrade request.")
+var ERROR_BAD_HANDSHAKE = fmt.Errorf("bad websocket handshake.")
+var ERROR_UNSUPPORTED_VERSION = fmt.Errorf("unsupported websocket version.")
+var ERROR_FORBIDDEN_ORIGIN = fmt.Errorf("websocket origin not allowed.")
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize caps a message, fragments included
+const DefaultMaxMessageSize = 16 << 20
+
+type Options struct {
+	// Subprotocols the server speaks in order of preference
+	Subprotocols []string
+	// CheckOrigin rejects cross-origin requests with 403 when it returns
+	// false, nil allows every origin
+	CheckOrigin func(req *request.Request) bool
+	// MaxMessageSize closes the connection with 1009 for larger messages,
+	// 0 means DefaultMaxMessageSize
+	MaxMessageSize int
+}
+
+// Upgrade performs the opening handshake of RFC 6455 §4.2 and takes the
+// connection over. On failure an error response has already been sent
+func Upgrade(w *response.Writer, req *request.Request, opts *Options) (*Conn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if req.RequestLine.Method != "GET" || req.RequestLine.IsHTTP10() ||
+		!req.Headers.HasToken("Connection", "upgrade") || !req.Headers.HasToken("Upgrade", "websocket") {
+		reject(w, response.StatusBadReq, ERROR_NOT_WEBSOCKET)
+		return nil, ERROR_NOT_WEBSOCKET
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); version != "13" {
+		// RFC 6455 §4.4, tell the client which version we speak
+		w.Header().Set("Sec-WebSocket-Version", "13", true)
+		w.Header().Set("Upgrade", "websocket", true)
+		reject(w, response.StatusUpgradeRequired, ERROR_UNSUPPORTED_VERSION)
+		return nil, ERROR_UNSUPPORTED_VERSION
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		reject(w, response.StatusBadReq, ERROR_BAD_HANDSHAKE)
+		return nil, ERROR_BAD_HANDSHAKE
+	}
+	if opts.CheckOrigin != nil && !opts.CheckOrigin(req) {
+		reject(w, response.StatusForbidden, ERROR_FORBIDDEN_ORIGIN)
+		return nil, ERROR_FORBIDDEN_ORIGIN
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket", true)
+	h.Set("Connection", "Upgrade", true)
+	h.Set("Sec-WebSocket-Accept", acceptKey(key), true)
+	protocol := selectSubprotocol(req, opts.Subprotocols)
+	if protocol != "" {
+		h.Set("Sec-WebSocket-Protocol", protocol, true)
+	}
+
+	b := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", response.StatusSwitchingProtocols, response.StatusText(response.StatusSwitchingProtocols))
+	h.ForEach(func(k, v string) {
+		b = fmt.Appendf(b, "%s: %s\r\n", k, v)
+	})
+	b = append(b, response.CRLF...)
+	if _, err := conn.Write(b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := newConn(conn, buffered, true)
+	c.subprotocol = protocol
+	if opts.MaxMessageSize > 0 {
+		c.maxMessageSize = opts.MaxMessageSize
+	}
+	return c, nil
+}
+
+func reject(w *response.Writer, status response.StatusCode, err error) {
+	w.Text(status, err.Error()+"\n")
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// selectSubprotocol picks the first server protocol the client offered
+func selectSubprotocol(req *request.Request, supported []string) string {
+	offered := []string{}
+	for _, value := range req.Headers.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(value, ",") {
+			offered = append(offered, strings.TrimSpace(p))
+		}
+	}
+	for _, p := range supported {
+		for _, o := range offered {
+			if p == o {
+				return p
+			}
+		}
+	}
+	return ""
+}
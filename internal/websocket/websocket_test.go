@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"tcpToHttp/internal/request"
+	"tcpToHttp/internal/response"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handshake = "GET /ws HTTP/1.1\r\n" +
+	"Host: localhost\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+func newRequest(t *testing.T, head string) *request.Request {
+	req, err := request.HeadFromReader(strings.NewReader(head + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestUpgrade(t *testing.T) {
+	// Test: Successful handshake, a frame sent right after the request
+	// is already buffered by the request parser
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	req := newRequest(t, handshake+"Sec-WebSocket-Protocol: chat, superchat\r\n")
+	w := response.NewWriter(&bytes.Buffer{})
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		return serverSide, clientFrame(true, 0, TextMessage, []byte("early")), nil
+	})
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		conn, err := Upgrade(w, req, &Options{Subprotocols: []string{"superchat", "chat"}})
+		done <- result{conn, err}
+	}()
+
+	br := bufio.NewReader(clientSide)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	head := ""
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head += line
+	}
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "sec-websocket-protocol: superchat\r\n")
+	assert.Contains(t, head, "upgrade: websocket\r\n")
+
+	res := <-done
+	require.NoError(t, res.err)
+	assert.True(t, w.Hijacked())
+	assert.Equal(t, "superchat", res.conn.Subprotocol())
+	messageType, data, err := res.conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, messageType)
+	assert.Equal(t, "early", string(data))
+
+	// Test: Rejected handshakes
+	tests := []struct {
+		head   string
+		opts   *Options
+		status string
+		err    error
+	}{
+		{"GET /ws HTTP/1.1\r\nHost: localhost\r\n", nil, "400", ERROR_NOT_WEBSOCKET},
+		{strings.Replace(handshake, "GET", "POST", 1), nil, "400", ERROR_NOT_WEBSOCKET},
+		{strings.Replace(handshake, "Version: 13", "Version: 8", 1), nil, "426", ERROR_UNSUPPORTED_VERSION},
+		{strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "short", 1), nil, "400", ERROR_BAD_HANDSHAKE},
+		{handshake + "Origin: http://evil.example\r\n", &Options{CheckOrigin: func(req *request.Request) bool {
+			origin, _ := req.Headers.Get("Origin")
+			return origin == "http://localhost"
+		}}, "403", ERROR_FORBIDDEN_ORIGIN},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		w := response.NewWriter(buf)
+		_, err := Upgrade(w, newRequest(t, tt.head), tt.opts)
+		assert.Equal(t, tt.err, err)
+		require.NoError(t, w.Finish())
+		assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 "+tt.status+" "), buf.String())
+		if tt.status == "426" {
+			assert.Contains(t, buf.String(), "sec-websocket-version: 13\r\n")
+		}
+	}
+
+	// Test: No hijacker available
+	_, err = Upgrade(response.NewWriter(&bytes.Buffer{}), newRequest(t, handshake), nil)
+	assert.Equal(t, response.ERROR_HIJACK_UNSUPPORTED, err)
+}
+
+func TestConnClientServer(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	server := newConn(serverSide, nil, true)
+	client := newConn(clientSide, nil, false)
+
+	// Test: Messages both ways with masking and pings
+	go func() {
+		for {
+			messageType, data, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			server.WriteMessage(messageType, data)
+		}
+	}()
+
+	pongs := make(chan string, 1)
+	client.PongHandler = func(data []byte) {
+		pongs <- string(data)
+	}
+	big := bytes.Repeat([]byte("x"), 70000)
+	// net.Pipe has no buffer so writes run while the client reads
+	writeErr := make(chan error, 1)
+	go func() {
+		if err := client.Ping([]byte("are you there")); err != nil {
+			writeErr <- err
+			return
+		}
+		writeErr <- client.WriteMessage(BinaryMessage, big)
+	}()
+	messageType, data, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, big, data)
+	assert.Equal(t, "are you there", <-pongs)
+	require.NoError(t, <-writeErr)
+
+	// Test: Closing handshake initiated by the client
+	require.NoError(t, client.Close(CloseGoingAway, "bye"))
+	assert.Equal(t, ERROR_CLOSE_SENT, client.WriteMessage(TextMessage, []byte("late")))
+}
+
+func TestCloseReasonTruncated(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	server := newConn(serverSide, nil, true)
+	client := newConn(clientSide, nil, false)
+
+	// Test: A long reason is cut at a rune boundary
+	closed := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		closed <- err
+	}()
+	require.NoError(t, client.Close(CloseNormal, strings.Repeat("é", 100)))
+	var closeErr *CloseError
+	require.ErrorAs(t, <-closed, &closeErr)
+	assert.Equal(t, CloseNormal, closeErr.Code)
+	assert.True(t, utf8.ValidString(closeErr.Reason))
+	assert.Equal(t, strings.Repeat("é", 61), closeErr.Reason)
+}